@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// Export writes the files to w as a gzipped tarball.
+// Paths in the tarball are relative to dir.
+func Export(w io.Writer, dir string, files []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	seen := mapset.NewSet[string]()
+
+	for _, path := range files {
+		if !seen.Add(path) {
+			continue
+		}
+
+		relpath, err := filepath.Rel(dir, path)
+		if err != nil || !local(relpath) {
+			return fmt.Errorf("%s is outside of %s", path, dir)
+		}
+
+		if err := addFile(tw, path, filepath.ToSlash(relpath)); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// Import extracts a tarball written by Export into dir
+// and returns the number of files that were extracted.
+func Import(r io.Reader, dir string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		relpath := filepath.FromSlash(h.Name)
+		if !local(filepath.Clean(relpath)) {
+			return n, fmt.Errorf("tarball contains invalid path %s", h.Name)
+		}
+
+		if err := writeFile(filepath.Join(dir, relpath), tr, os.FileMode(h.Mode).Perm()); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func addFile(tw *tar.Writer, path string, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name
+
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
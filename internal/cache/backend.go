@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Backend when a key isn't stored.
+var ErrNotFound = errors.New("cache: not found")
+
+// Backend is a remote store of cache entries.
+//
+// Keys are of the form "ac/<key>" for the list of outputs of a
+// task and "cas/<sha256>" for the contents of a file.
+type Backend interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+type httpBackend struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP returns a Backend that uses GET and PUT requests
+// to <url>/<key>. This is compatible with a static file server
+// that accepts uploads or a bazel-remote style endpoint.
+func NewHTTP(url string) Backend {
+	return &httpBackend{
+		url: strings.TrimSuffix(url, "/"),
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+	}
+}
+
+// Get implements Backend
+func (b *httpBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url+"/"+key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrNotFound
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		res.Body.Close()
+		return nil, fmt.Errorf("cache: GET %s returned %s", key, res.Status)
+	}
+
+	return res.Body, nil
+}
+
+// Put implements Backend
+func (b *httpBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.url+"/"+key, r)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("cache: PUT %s returned %s", key, res.Status)
+	}
+
+	return nil
+}
+
+var _ Backend = &httpBackend{}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryServer struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func (s *memoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	switch r.Method {
+	case http.MethodGet:
+		b, ok := s.entries[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		s.entries[key] = b
+	}
+}
+
+func newRemote(t *testing.T) (*memoryServer, Backend) {
+	s := &memoryServer{entries: map[string][]byte{}}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, NewHTTP(server.URL)
+}
+
+func writeOutput(t *testing.T, cwd string) string {
+	out := filepath.Join(cwd, "out.txt")
+	require.NoError(t, ioutil.WriteFile(out, []byte("output"), 0644))
+	return out
+}
+
+func TestRemoteRestore(t *testing.T) {
+	require := require.New(t)
+
+	_, remote := newRemote(t)
+	cwd := t.TempDir()
+	out := writeOutput(t, cwd)
+
+	writer := &Cache{Dir: t.TempDir(), Remote: remote}
+	require.NoError(writer.Store(context.Background(), "key", cwd, []string{out}))
+	require.NoError(os.Remove(out))
+
+	// a different machine with an empty local cache
+	reader := &Cache{Dir: t.TempDir(), Remote: remote}
+	restored, err := reader.Restore(context.Background(), "key", cwd)
+	require.NoError(err)
+	require.True(restored)
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(err)
+	require.Equal("output", string(b))
+}
+
+func TestRemoteReadOnly(t *testing.T) {
+	require := require.New(t)
+
+	s, remote := newRemote(t)
+	cwd := t.TempDir()
+	out := writeOutput(t, cwd)
+
+	c := &Cache{Dir: t.TempDir(), Remote: remote, ReadOnly: true}
+	require.NoError(c.Store(context.Background(), "key", cwd, []string{out}))
+	require.Empty(s.entries)
+}
+
+func TestRemoteError(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := &Cache{Dir: t.TempDir(), Remote: NewHTTP(server.URL)}
+
+	restored, err := c.Restore(context.Background(), "key", t.TempDir())
+	require.Error(err)
+	require.False(restored)
+}
@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Cache is a local content-addressed store of task outputs.
+//
+// The contents of each file are stored once under cas/<sha256> and
+// the outputs of a task are described by an entry under ac/<key>
+// which lists the files that were produced.
+//
+// If a Remote backend is configured then entries missing from the
+// local directory are fetched from it and new entries are uploaded
+// to it unless ReadOnly is set.
+type Cache struct {
+	Dir      string
+	Remote   Backend
+	ReadOnly bool
+}
+
+func New(dir string) *Cache {
+	return &Cache{
+		Dir: dir,
+	}
+}
+
+type entry struct {
+	Path   string      `json:"path"`
+	Mode   os.FileMode `json:"mode"`
+	Digest string      `json:"digest"`
+}
+
+type manifest struct {
+	Files []entry `json:"files"`
+}
+
+// Store copies the files into the cache under the given key.
+// The files must be within cwd and are restored relative to it.
+func (c *Cache) Store(ctx context.Context, key string, cwd string, files []string) error {
+	m := manifest{
+		Files: []entry{},
+	}
+
+	for _, path := range files {
+		relpath, err := filepath.Rel(cwd, path)
+		if err != nil || !local(relpath) {
+			return fmt.Errorf("output %s is outside of %s", path, cwd)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		digest, err := c.storeBlob(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to cache %s", path)
+		}
+
+		m.Files = append(m.Files, entry{
+			Path:   filepath.ToSlash(relpath),
+			Mode:   fi.Mode().Perm(),
+			Digest: digest,
+		})
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(c.actionPath(key), bytes.NewReader(b), 0644); err != nil {
+		return err
+	}
+
+	if c.Remote == nil || c.ReadOnly {
+		return nil
+	}
+
+	// the file contents are uploaded first so that the remote
+	// never has an entry referring to missing contents.
+	for _, e := range m.Files {
+		if err := c.upload(ctx, "cas/"+e.Digest, c.blobPath(e.Digest)); err != nil {
+			return err
+		}
+	}
+
+	return c.upload(ctx, "ac/"+key, c.actionPath(key))
+}
+
+// Restore writes the files stored under key back into cwd.
+// It returns false if the key isn't in the cache.
+func (c *Cache) Restore(ctx context.Context, key string, cwd string) (bool, error) {
+	m, err := c.load(key)
+	if err != nil {
+		return false, err
+	}
+
+	if m == nil && c.Remote != nil {
+		if ok, err := c.download(ctx, "ac/"+key, c.actionPath(key), ""); err != nil || !ok {
+			return false, err
+		}
+		if m, err = c.load(key); err != nil {
+			os.Remove(c.actionPath(key))
+			return false, err
+		}
+	}
+
+	if m == nil {
+		return false, nil
+	}
+
+	// check everything is available before touching the
+	// filesystem so that a partial cache entry is a miss.
+	for _, e := range m.Files {
+		if !local(filepath.FromSlash(e.Path)) {
+			return false, fmt.Errorf("cache entry %s contains invalid path %s", key, e.Path)
+		}
+		if _, err := os.Stat(c.blobPath(e.Digest)); os.IsNotExist(err) {
+			if c.Remote == nil {
+				return false, nil
+			}
+			if ok, err := c.download(ctx, "cas/"+e.Digest, c.blobPath(e.Digest), e.Digest); err != nil || !ok {
+				return false, err
+			}
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	for _, e := range m.Files {
+		if err := c.restoreBlob(e, filepath.Join(cwd, filepath.FromSlash(e.Path))); err != nil {
+			return false, errors.Wrapf(err, "failed to restore %s", e.Path)
+		}
+	}
+
+	return true, nil
+}
+
+// Files returns the paths of everything stored in the
+// cache for the given key.
+func (c *Cache) Files(key string) ([]string, error) {
+	m, err := c.load(key)
+	if err != nil || m == nil {
+		return []string{}, err
+	}
+
+	files := []string{c.actionPath(key)}
+	for _, e := range m.Files {
+		files = append(files, c.blobPath(e.Digest))
+	}
+
+	return files, nil
+}
+
+func (c *Cache) load(key string) (*manifest, error) {
+	b, err := ioutil.ReadFile(c.actionPath(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Wrapf(err, "failed to read cache entry %s", key)
+	}
+
+	return m, nil
+}
+
+func (c *Cache) storeBlob(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	digest := fmt.Sprintf("%x", h.Sum(nil))
+
+	if _, err := os.Stat(c.blobPath(digest)); err == nil {
+		return digest, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return digest, writeFile(c.blobPath(digest), f, 0644)
+}
+
+// upload copies a local file to the remote backend.
+func (c *Cache) upload(ctx context.Context, key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.Remote.Put(ctx, key, f)
+}
+
+// download copies an entry from the remote backend into the local
+// directory. If digest is given then the contents are verified
+// against it. It returns false if the remote doesn't have the key.
+func (c *Cache) download(ctx context.Context, key string, path string, digest string) (bool, error) {
+	r, err := c.Remote.Get(ctx, key)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to download %s", key)
+	}
+
+	if digest != "" && fmt.Sprintf("%x", sha256.Sum256(b)) != digest {
+		return false, fmt.Errorf("downloaded contents of %s don't match the digest", key)
+	}
+
+	if err := writeFile(path, bytes.NewReader(b), 0644); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *Cache) restoreBlob(e entry, path string) error {
+	f, err := os.Open(c.blobPath(e.Digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(path, f, e.Mode)
+}
+
+func (c *Cache) actionPath(key string) string {
+	return filepath.Join(c.Dir, "ac", key)
+}
+
+func (c *Cache) blobPath(digest string) string {
+	return filepath.Join(c.Dir, "cas", digest)
+}
+
+// writeFile atomically replaces path with the contents of r.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// local reports whether a relative path stays within its root.
+func local(relpath string) bool {
+	return !filepath.IsAbs(relpath) && relpath != ".." && !strings.HasPrefix(relpath, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreAndRestore(t *testing.T) {
+	require := require.New(t)
+
+	c := New(t.TempDir())
+	cwd := t.TempDir()
+
+	out := filepath.Join(cwd, "bin", "out.txt")
+	require.NoError(os.MkdirAll(filepath.Dir(out), 0755))
+	require.NoError(ioutil.WriteFile(out, []byte("output"), 0755))
+
+	require.NoError(c.Store(context.Background(), "key", cwd, []string{out}))
+	require.NoError(os.RemoveAll(filepath.Join(cwd, "bin")))
+
+	restored, err := c.Restore(context.Background(), "key", cwd)
+	require.NoError(err)
+	require.True(restored)
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(err)
+	require.Equal("output", string(b))
+
+	fi, err := os.Stat(out)
+	require.NoError(err)
+	require.Equal(os.FileMode(0755), fi.Mode().Perm())
+}
+
+func TestRestoreMissingKey(t *testing.T) {
+	require := require.New(t)
+
+	c := New(t.TempDir())
+
+	restored, err := c.Restore(context.Background(), "missing", t.TempDir())
+	require.NoError(err)
+	require.False(restored)
+}
+
+func TestStoreOutsideCwd(t *testing.T) {
+	require := require.New(t)
+
+	c := New(t.TempDir())
+	cwd := t.TempDir()
+
+	out := filepath.Join(filepath.Dir(cwd), "out.txt")
+
+	require.Error(c.Store(context.Background(), "key", cwd, []string{out}))
+}
+
+func TestExportAndImport(t *testing.T) {
+	require := require.New(t)
+
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	path := filepath.Join(src, "cas", "digest")
+	require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(ioutil.WriteFile(path, []byte("content"), 0644))
+
+	buf := &bytes.Buffer{}
+	require.NoError(Export(buf, src, []string{path, path}))
+
+	n, err := Import(buf, dst)
+	require.NoError(err)
+	require.Equal(1, n)
+
+	b, err := ioutil.ReadFile(filepath.Join(dst, "cas", "digest"))
+	require.NoError(err)
+	require.Equal("content", string(b))
+}
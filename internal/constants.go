@@ -4,4 +4,5 @@ const (
 	AppName       = "taskgraph"
 	WorkspaceFile = "Taskgraph.workspace"
 	BuildFile     = "Taskgraph"
+	StateDir      = ".taskgraph"
 )
@@ -29,8 +29,18 @@ type RunCommandOptions struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// KillTimeout is how long a command has to exit after it is
+	// interrupted before it is killed, it defaults to DefaultKillTimeout.
+	KillTimeout time.Duration
+	// Isolated commands run in their own process group and are only
+	// interrupted through the context, not by a ctrl+c in the terminal.
+	Isolated bool
 }
 
+// DefaultKillTimeout is used when RunCommandOptions.KillTimeout isn't set.
+const DefaultKillTimeout = 2 * time.Second
+
 var (
 	// ErrNilOptions is returned when a nil options is given
 	ErrNilOptions = errors.New("execext: nil options given")
@@ -52,10 +62,15 @@ func RunCommand(ctx context.Context, cmd string, opts *RunCommandOptions) error
 		environ = os.Environ()
 	}
 
+	killTimeout := opts.KillTimeout
+	if killTimeout <= 0 {
+		killTimeout = DefaultKillTimeout
+	}
+
 	r, err := interp.New(
 		interp.Params("-e"),
 		interp.Env(expand.ListEnviron(environ...)),
-		interp.ExecHandler(ExecHandler(2*time.Second)),
+		interp.ExecHandler(ExecHandler(killTimeout, opts.Isolated)),
 		interp.OpenHandler(openHandler),
 		interp.StdIO(opts.Stdin, opts.Stdout, opts.Stderr),
 		dirOption(opts.Dir),
@@ -128,7 +143,7 @@ func dirOption(path string) interp.RunnerOption {
 	}
 }
 
-func ExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
+func ExecHandler(killTimeout time.Duration, isolated bool) interp.ExecHandlerFunc {
 	return func(ctx context.Context, args []string) error {
 		hc := interp.HandlerCtx(ctx)
 		path, err := interp.LookPathDir(hc.Dir, hc.Env, args[0])
@@ -146,49 +161,60 @@ func ExecHandler(killTimeout time.Duration) interp.ExecHandlerFunc {
 			Stderr: hc.Stderr,
 		}
 
+		if isolated {
+			isolate(&cmd)
+		}
+
 		wg, ctx := errgroup.WithContext(ctx)
 		procdone := make(chan struct{}, 1)
 
-		wg.Go(func() error {
-			defer func() {
-				procdone <- struct{}{}
-			}()
+		// the process is started before watching the context so that
+		// it can always be signalled once the context is done.
+		if err = cmd.Start(); err == nil {
+			wg.Go(func() error {
+				defer func() {
+					procdone <- struct{}{}
+				}()
 
-			if err := cmd.Start(); err != nil {
-				return err
-			}
+				return cmd.Wait()
+			})
 
-			return cmd.Wait()
-		})
+			wg.Go(func() error {
+				done := false
 
-		wg.Go(func() error {
-			done := false
+				select {
+				case <-ctx.Done():
+				case <-procdone:
+					done = true
+				}
 
-			select {
-			case <-ctx.Done():
-			case <-procdone:
-				done = true
-			}
+				if !done {
+					signal := cmd.Process.Signal
+					if isolated {
+						signal = func(sig os.Signal) error {
+							return signalGroup(cmd.Process, sig)
+						}
+					}
 
-			if !done {
-				if killTimeout <= 0 || runtime.GOOS == "windows" {
-					return cmd.Process.Signal(os.Kill)
-				}
+					if killTimeout <= 0 || runtime.GOOS == "windows" {
+						return signal(os.Kill)
+					}
 
-				cmd.Process.Signal(os.Interrupt)
+					signal(os.Interrupt)
 
-				select {
-				case <-procdone:
+					select {
+					case <-procdone:
+						return nil
+					case <-time.After(killTimeout):
+						return signal(os.Kill)
+					}
+				} else {
 					return nil
-				case <-time.After(killTimeout):
-					return cmd.Process.Signal(os.Kill)
 				}
-			} else {
-				return nil
-			}
-		})
+			})
 
-		err = wg.Wait()
+			err = wg.Wait()
+		}
 
 		switch x := err.(type) {
 		case *exec.ExitError:
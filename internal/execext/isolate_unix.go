@@ -0,0 +1,25 @@
+//go:build !windows
+
+package execext
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts the command in its own process group so that
+// a ctrl+c in the terminal isn't delivered to it directly.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup signals every process in the group of an
+// isolated command, so that its children stop too.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+	return syscall.Kill(-p.Pid, s)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package execext
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts the command in its own process group so that
+// a ctrl+c in the terminal isn't delivered to it directly.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalGroup signals the command, windows has no
+// equivalent of signalling a whole process group.
+func signalGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
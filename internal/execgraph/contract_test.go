@@ -2,8 +2,11 @@ package execgraph
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -40,3 +43,198 @@ func TestExample(t *testing.T) {
 	err := graph.Execute(context.Background(), "1")
 	require.NoError(err)
 }
+
+// tracker records the maximum number of callbacks running at once.
+type tracker struct {
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+}
+
+func (t *tracker) task(ctx context.Context) error {
+	t.mu.Lock()
+	t.running++
+	if t.running > t.maxRunning {
+		t.maxRunning = t.running
+	}
+	t.mu.Unlock()
+
+	<-time.After(20 * time.Millisecond)
+
+	t.mu.Lock()
+	t.running--
+	t.mu.Unlock()
+	return nil
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	require := require.New(t)
+
+	graph := New(WithConcurrency(2))
+
+	tasks := &tracker{}
+	graph.Add("root", tasks.task)
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("dep-%d", i)
+		graph.Add(id, tasks.task)
+		graph.AddDependency("root", id)
+	}
+
+	require.NoError(graph.Execute(context.Background(), "root"))
+	require.Equal(2, tasks.maxRunning)
+}
+
+func TestResourcesSerializeNodes(t *testing.T) {
+	require := require.New(t)
+
+	graph := New(WithConcurrency(8))
+
+	db := &tracker{}
+	all := &tracker{}
+
+	graph.Add("root", all.task)
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("db-%d", i)
+		graph.Add(id, func(ctx context.Context) error {
+			all.task(ctx)
+			return db.task(ctx)
+		}, Requires("db"))
+		graph.AddDependency("root", id)
+
+		id = fmt.Sprintf("other-%d", i)
+		graph.Add(id, all.task)
+		graph.AddDependency("root", id)
+	}
+
+	require.NoError(graph.Execute(context.Background(), "root"))
+	require.Equal(1, db.maxRunning)
+	require.Greater(all.maxRunning, 1)
+}
+
+func TestResourceCapacity(t *testing.T) {
+	require := require.New(t)
+
+	graph := New(WithResources(map[string]int{"nuget": 2}))
+
+	nuget := &tracker{}
+
+	graph.Add("root", func(ctx context.Context) error { return nil })
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("dep-%d", i)
+		// requiring two resources in different orders doesn't deadlock
+		resources := []string{"nuget", "db"}
+		if i%2 == 0 {
+			resources = []string{"db", "nuget"}
+		}
+		graph.Add(id, nuget.task, Requires(resources...))
+		graph.AddDependency("root", id)
+	}
+
+	require.NoError(graph.Execute(context.Background(), "root"))
+	require.Equal(1, nuget.maxRunning)
+
+	graph = New(WithResources(map[string]int{"nuget": 2}))
+	nuget = &tracker{}
+
+	graph.Add("root", func(ctx context.Context) error { return nil })
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("dep-%d", i)
+		graph.Add(id, nuget.task, Requires("nuget"))
+		graph.AddDependency("root", id)
+	}
+
+	require.NoError(graph.Execute(context.Background(), "root"))
+	require.Equal(2, nuget.maxRunning)
+}
+
+func newFailingGraph(opts ...Option) (*ExecutionGraph, *sync.Map) {
+	graph := New(opts...)
+	ran := &sync.Map{}
+
+	ok := func(name string) Callback {
+		return func(ctx context.Context) error {
+			ran.Store(name, true)
+			return nil
+		}
+	}
+
+	// "slow" only starts after "fails" has failed
+	graph.Add("root", ok("root"))
+	graph.Add("fails", func(ctx context.Context) error {
+		<-time.After(10 * time.Millisecond)
+		return errors.New("boom")
+	})
+	graph.Add("dependent", ok("dependent"))
+	graph.Add("wait", func(ctx context.Context) error {
+		<-time.After(50 * time.Millisecond)
+		return nil
+	})
+	graph.Add("slow", ok("slow"))
+
+	graph.AddDependency("root", "dependent")
+	graph.AddDependency("root", "slow")
+	graph.AddDependency("dependent", "fails")
+	graph.AddDependency("slow", "wait")
+
+	return graph, ran
+}
+
+func TestKeepGoing(t *testing.T) {
+	require := require.New(t)
+
+	graph, ran := newFailingGraph(WithKeepGoing(true))
+
+	err := graph.Execute(context.Background(), "root")
+	require.EqualError(err, "fails: boom")
+
+	_, ok := ran.Load("slow")
+	require.True(ok)
+
+	results := graph.Results()
+	require.IsType(&SkippedError{}, results["dependent"])
+	require.IsType(&SkippedError{}, results["root"])
+}
+
+func TestStopsAfterFailure(t *testing.T) {
+	require := require.New(t)
+
+	graph, ran := newFailingGraph()
+
+	err := graph.Execute(context.Background(), "root")
+	require.EqualError(err, "fails: boom")
+
+	_, ok := ran.Load("slow")
+	require.False(ok)
+	require.EqualError(graph.Results()["slow"], "slow skipped (another task failed)")
+}
+
+func TestCancelsRunningTasksAfterFailure(t *testing.T) {
+	require := require.New(t)
+
+	graph := New()
+
+	graph.Add("root", func(ctx context.Context) error {
+		return nil
+	})
+	graph.Add("fails", func(ctx context.Context) error {
+		<-time.After(10 * time.Millisecond)
+		return errors.New("boom")
+	})
+	graph.Add("long", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+
+	graph.AddDependency("root", "fails")
+	graph.AddDependency("root", "long")
+
+	start := time.Now()
+	err := graph.Execute(context.Background(), "root")
+	require.EqualError(err, "fails: boom")
+	require.Less(time.Since(start), time.Second)
+	require.EqualError(graph.Results()["long"], "long skipped (cancelled because another task failed)")
+}
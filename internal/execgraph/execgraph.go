@@ -2,10 +2,15 @@ package execgraph
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dominikbraun/graph"
 	"github.com/samber/lo"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/semaphore"
 )
 
 func Hello() error {
@@ -16,30 +21,135 @@ func Hello() error {
 type Callback func(ctx context.Context) error
 
 type ExecutionGraph struct {
-	rw    sync.RWMutex
-	graph graph.Graph[string, *executionNode]
+	rw        sync.RWMutex
+	graph     graph.Graph[string, *executionNode]
+	jobs      *semaphore.Weighted
+	resources map[string]*semaphore.Weighted
+	keepGoing bool
+	tracer    Tracer
+	failed    int32
+	cancel    context.CancelFunc
 }
 
-func New() *ExecutionGraph {
+// Tracer records how long each phase of executing a node takes.
+type Tracer interface {
+	// Span starts a span and returns a function that ends it.
+	Span(track string, name string, category string) func()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Span(track string, name string, category string) func() {
+	return func() {}
+}
+
+// SkippedError is the result of a node whose callback
+// wasn't invoked because of a failure elsewhere in the graph.
+type SkippedError struct {
+	ID     string
+	Reason string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("%s skipped (%s)", e.ID, e.Reason)
+}
+
+type Option func(g *ExecutionGraph)
+
+// WithConcurrency limits the number of callbacks that run at the
+// same time. A node only holds a slot while its callback runs, not
+// while it waits for its dependencies.
+func WithConcurrency(n int) Option {
+	return func(g *ExecutionGraph) {
+		if n > 0 {
+			g.jobs = semaphore.NewWeighted(int64(n))
+		}
+	}
+}
+
+// WithResources creates a pool for each named resource that allows
+// up to capacity nodes requiring the resource to run at the same time.
+// Resources required by a node without a pool have a capacity of 1.
+func WithResources(capacities map[string]int) Option {
+	return func(g *ExecutionGraph) {
+		for name, capacity := range capacities {
+			g.resources[name] = semaphore.NewWeighted(int64(capacity))
+		}
+	}
+}
+
+// WithKeepGoing keeps executing every node that doesn't depend on a
+// failed node. Otherwise once a node fails no new callbacks are started
+// and the context of the callbacks that are running is cancelled.
+func WithKeepGoing(enabled bool) Option {
+	return func(g *ExecutionGraph) {
+		g.keepGoing = enabled
+	}
+}
+
+// WithTracer records the time each node spends waiting
+// for its dependencies and executing its callback.
+func WithTracer(t Tracer) Option {
+	return func(g *ExecutionGraph) {
+		g.tracer = t
+	}
+}
+
+func New(opts ...Option) *ExecutionGraph {
 	hasher := func(node *executionNode) string {
 		return node.id
 	}
-	return &ExecutionGraph{
-		graph: graph.New(hasher, graph.Directed(), graph.Acyclic(), graph.PreventCycles()),
+	g := &ExecutionGraph{
+		graph:     graph.New(hasher, graph.Directed(), graph.Acyclic(), graph.PreventCycles()),
+		resources: map[string]*semaphore.Weighted{},
+		tracer:    noopTracer{},
 	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
 }
 
-func (g *ExecutionGraph) Add(id string, fn Callback) error {
+type NodeOption func(n *executionNode)
+
+// Requires holds one unit of each resource while the callback runs, so
+// nodes sharing a scarce resource don't run at the same time as each other.
+func Requires(resources ...string) NodeOption {
+	return func(n *executionNode) {
+		n.resources = append(n.resources, resources...)
+	}
+}
+
+func (g *ExecutionGraph) Add(id string, fn Callback, opts ...NodeOption) error {
 	g.rw.Lock()
 	defer g.rw.Unlock()
-	return g.graph.AddVertex(&executionNode{
+
+	node := &executionNode{
 		graph: g,
 		id:    id,
 		fn:    fn,
-	})
+	}
+	for _, opt := range opts {
+		opt(node)
+	}
+
+	// resources are always acquired in the same order
+	// so that two nodes can't deadlock each other.
+	node.resources = lo.Uniq(node.resources)
+	sort.Strings(node.resources)
+	for _, name := range node.resources {
+		if _, ok := g.resources[name]; !ok {
+			g.resources[name] = semaphore.NewWeighted(1)
+		}
+	}
+
+	return g.graph.AddVertex(node)
 }
 
 func (g *ExecutionGraph) Execute(ctx context.Context, root string) error {
+	ctx, g.cancel = context.WithCancel(ctx)
+	defer g.cancel()
+
 	execgraph, err := g.graph.Clone()
 	if err != nil {
 		return err
@@ -58,7 +168,63 @@ func (g *ExecutionGraph) Execute(ctx context.Context, root string) error {
 		return err
 	}
 
-	return node.execute(ctx).Get()
+	node.execute(ctx).Get()
+
+	// every failure is reported once, rather than once
+	// for each path through the graph that leads to it.
+	results := g.Results()
+	ids := lo.Keys(results)
+	sort.Strings(ids)
+
+	failures := []error{}
+	for _, id := range ids {
+		if err := results[id]; err != nil && !isSkipped(err) {
+			failures = append(failures, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+
+	return multierr.Combine(failures...)
+}
+
+// Results returns the result of every node that was
+// reached by Execute, keyed by the node id.
+func (g *ExecutionGraph) Results() map[string]error {
+	g.rw.RLock()
+	defer g.rw.RUnlock()
+
+	m, err := g.graph.AdjacencyMap()
+	if err != nil {
+		panic(err)
+	}
+
+	results := map[string]error{}
+	for id := range m {
+		node, _ := g.graph.Vertex(id)
+		if f := node.result(); f != nil {
+			results[id] = f.Get()
+		}
+	}
+
+	return results
+}
+
+// fail records that a node failed and returns true if it was
+// the first one to do so.
+func (g *ExecutionGraph) fail() bool {
+	first := atomic.CompareAndSwapInt32(&g.failed, 0, 1)
+	if !g.keepGoing {
+		g.cancel()
+	}
+	return first
+}
+
+func (g *ExecutionGraph) hasFailed() bool {
+	return atomic.LoadInt32(&g.failed) == 1
+}
+
+func isSkipped(err error) bool {
+	_, ok := err.(*SkippedError)
+	return ok
 }
 
 func (g *ExecutionGraph) AddDependency(from string, to string) error {
@@ -6,7 +6,7 @@ import (
 	"taskgraph/internal/execgraph/future"
 
 	"github.com/samber/lo"
-	"go.uber.org/multierr"
+	"golang.org/x/sync/semaphore"
 )
 
 type executionNode struct {
@@ -14,6 +14,8 @@ type executionNode struct {
 	graph *ExecutionGraph
 	fn    func(ctx context.Context) error
 
+	resources []string
+
 	futurerw sync.RWMutex
 	future   future.Future[error]
 }
@@ -24,19 +26,107 @@ func (node *executionNode) execute(ctx context.Context) future.Future[error] {
 
 	if node.future == nil {
 		node.future = future.New(func() error {
+			tracer := node.graph.tracer
+
 			deps := node.graph.findDependencies(node.id)
 
+			end := tracer.Span(node.id, "waiting for dependencies", "wait")
 			errors := future.All(lo.Map(deps, func(dep *executionNode, i int) future.Future[error] {
 				return dep.execute(ctx)
 			})).Get()
+			end()
+
+			if reason := skipReason(errors); reason != "" {
+				return &SkippedError{ID: node.id, Reason: reason}
+			}
 
-			if err := multierr.Combine(errors...); err != nil {
+			release, err := node.acquire(ctx)
+			defer release()
+			if err != nil && !node.graph.hasFailed() {
 				return err
 			}
 
-			return node.fn(ctx)
+			// checked after waiting for a slot because
+			// another node may have failed in the meantime.
+			if err != nil || (!node.graph.keepGoing && node.graph.hasFailed()) {
+				return &SkippedError{ID: node.id, Reason: "another task failed"}
+			}
+
+			end = tracer.Span(node.id, node.id, "execute")
+			err = node.fn(ctx)
+			end()
+
+			if err != nil {
+				// when a failure cancels the other running nodes their
+				// errors are a result of that rather than failures.
+				if !node.graph.fail() && !node.graph.keepGoing && ctx.Err() != nil {
+					return &SkippedError{ID: node.id, Reason: "cancelled because another task failed"}
+				}
+				return err
+			}
+
+			return nil
 		})
 	}
 
 	return node.future
 }
+
+// acquire waits for each resource the node requires and then for
+// a job slot. The returned function releases everything that was
+// acquired, even when an error is returned.
+func (node *executionNode) acquire(ctx context.Context) (func(), error) {
+	held := []*semaphore.Weighted{}
+	release := func() {
+		for _, s := range held {
+			s.Release(1)
+		}
+	}
+
+	for _, name := range node.resources {
+		s := node.graph.resources[name]
+		end := node.graph.tracer.Span(node.id, "waiting for resource "+name, "wait")
+		err := s.Acquire(ctx, 1)
+		end()
+		if err != nil {
+			return release, err
+		}
+		held = append(held, s)
+	}
+
+	if jobs := node.graph.jobs; jobs != nil {
+		end := node.graph.tracer.Span(node.id, "waiting for a job slot", "wait")
+		err := jobs.Acquire(ctx, 1)
+		end()
+		if err != nil {
+			return release, err
+		}
+		held = append(held, jobs)
+	}
+
+	return release, nil
+}
+
+// skipReason returns why a node with the given dependency
+// results must be skipped or "" if it can be executed.
+func skipReason(errors []error) string {
+	reason := ""
+	for _, err := range errors {
+		if skipped, ok := err.(*SkippedError); ok {
+			if reason == "" {
+				reason = skipped.Reason
+			}
+		} else if err != nil {
+			return "dependency failed"
+		}
+	}
+	return reason
+}
+
+// result returns the future of the node or nil
+// if the node hasn't been executed.
+func (node *executionNode) result() future.Future[error] {
+	node.futurerw.RLock()
+	defer node.futurerw.RUnlock()
+	return node.future
+}
@@ -0,0 +1,144 @@
+package filehash
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache remembers the digest of each file so that files are only
+// read again when their size, modification time or inode changes.
+//
+// A nil *Cache is valid and always reads the file.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]entry
+	dirty   bool
+}
+
+type entry struct {
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	Inode  uint64 `json:"inode"`
+	Digest string `json:"digest"`
+}
+
+// Load reads the cache stored at path. A missing or
+// unreadable file results in an empty cache.
+func Load(path string) *Cache {
+	c := &Cache{
+		path:    path,
+		entries: map[string]entry{},
+	}
+
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &c.entries); err != nil {
+			c.entries = map[string]entry{}
+		}
+	}
+
+	return c
+}
+
+// Digest returns the hex encoded SHA-256 of the contents of the file.
+func (c *Cache) Digest(path string) (string, error) {
+	if c == nil {
+		return digest(path)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	e := entry{
+		Size:  fi.Size(),
+		Mtime: fi.ModTime().UnixNano(),
+		Inode: inode(fi),
+	}
+
+	c.mu.Lock()
+	previous, ok := c.entries[path]
+	c.mu.Unlock()
+
+	if ok && previous.Size == e.Size && previous.Mtime == e.Mtime && previous.Inode == e.Inode {
+		return previous.Digest, nil
+	}
+
+	e.Digest, err = digest(path)
+	if err != nil {
+		return "", err
+	}
+
+	// a file modified within the timestamp resolution of the filesystem
+	// could change again without its mtime changing, so it isn't
+	// remembered until it has settled.
+	if time.Since(fi.ModTime()) > 2*time.Second {
+		c.mu.Lock()
+		c.entries[path] = e
+		c.dirty = true
+		c.mu.Unlock()
+	}
+
+	return e.Digest, nil
+}
+
+// Save writes the cache back to disk if anything changed.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	// forget files that no longer exist so the cache doesn't grow forever
+	for path := range c.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.entries, path)
+		}
+	}
+
+	b, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(c.path, b, 0644); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+func digest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
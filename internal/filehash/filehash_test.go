@@ -0,0 +1,58 @@
+package filehash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestIsRemembered(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(ioutil.WriteFile(path, []byte("content"), 0644))
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(os.Chtimes(path, old, old))
+
+	c := Load(filepath.Join(dir, "filehashes"))
+	d1, err := c.Digest(path)
+	require.NoError(err)
+	require.Equal("ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", d1)
+	require.NoError(c.Save())
+
+	// rewriting the file without changing its stat
+	// shows the digest came from the cache.
+	require.NoError(ioutil.WriteFile(path, []byte("CONTENT"), 0644))
+	require.NoError(os.Chtimes(path, old, old))
+
+	d2, err := Load(filepath.Join(dir, "filehashes")).Digest(path)
+	require.NoError(err)
+	require.Equal(d1, d2)
+}
+
+func TestDigestDetectsChanges(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(ioutil.WriteFile(path, []byte("content"), 0644))
+
+	old := time.Now().Add(-time.Hour)
+	require.NoError(os.Chtimes(path, old, old))
+
+	c := Load(filepath.Join(dir, "filehashes"))
+	d1, err := c.Digest(path)
+	require.NoError(err)
+
+	require.NoError(ioutil.WriteFile(path, []byte("changed"), 0644))
+
+	d2, err := c.Digest(path)
+	require.NoError(err)
+	require.NotEqual(d1, d2)
+}
@@ -0,0 +1,52 @@
+package history
+
+import (
+	"taskgraph/internal/rules"
+	"time"
+)
+
+// Step is a task on a critical path.
+type Step struct {
+	ID       string
+	Duration time.Duration
+	// Known is false when the task has never been executed,
+	// in which case it is counted as taking no time.
+	Known bool
+}
+
+// Path is the longest chain of dependent tasks leading to a target.
+type Path struct {
+	Steps []Step
+	Total time.Duration
+}
+
+// CriticalPath finds the chain of dependencies of target with the
+// longest total duration. The first step is the task that has to
+// run first and the last step is the target itself.
+func CriticalPath(target string, dependencies func(id string) []rules.Rule, durations *Durations) Path {
+	memo := map[string]Path{}
+
+	var walk func(id string) Path
+	walk = func(id string) Path {
+		if p, ok := memo[id]; ok {
+			return p
+		}
+
+		longest := Path{}
+		for _, d := range dependencies(id) {
+			if p := walk(d.ID()); len(longest.Steps) == 0 || p.Total > longest.Total {
+				longest = p
+			}
+		}
+
+		duration, known := durations.Get(id)
+		p := Path{
+			Steps: append(append([]Step{}, longest.Steps...), Step{ID: id, Duration: duration, Known: known}),
+			Total: longest.Total + duration,
+		}
+		memo[id] = p
+		return p
+	}
+
+	return walk(target)
+}
@@ -0,0 +1,95 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Durations remembers how long each task took
+// the last time it was executed.
+//
+// A nil *Durations is valid and remembers nothing.
+type Durations struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]time.Duration
+	dirty   bool
+}
+
+// Load reads the durations stored at path. A missing or
+// unreadable file results in no known durations.
+func Load(path string) *Durations {
+	d := &Durations{
+		path:    path,
+		entries: map[string]time.Duration{},
+	}
+
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &d.entries); err != nil {
+			d.entries = map[string]time.Duration{}
+		}
+	}
+
+	return d
+}
+
+// Get returns the last recorded duration of a task.
+func (d *Durations) Get(id string) (time.Duration, bool) {
+	if d == nil {
+		return 0, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	duration, ok := d.entries[id]
+	return duration, ok
+}
+
+// Set records the duration of a task.
+func (d *Durations) Set(id string, duration time.Duration) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries[id] = duration
+	d.dirty = true
+}
+
+// Save writes the durations back to disk if anything changed.
+func (d *Durations) Save() error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(d.entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(d.path, b, 0644); err != nil {
+		return err
+	}
+
+	d.dirty = false
+	return nil
+}
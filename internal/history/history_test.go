@@ -0,0 +1,102 @@
+package history
+
+import (
+	"path/filepath"
+	"taskgraph/internal/rules"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationsAreSaved(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "durations")
+
+	d := Load(path)
+	d.Set("//pkg:build", 3*time.Second)
+	require.NoError(d.Save())
+
+	duration, ok := Load(path).Get("//pkg:build")
+	require.True(ok)
+	require.Equal(3*time.Second, duration)
+
+	_, ok = Load(path).Get("//pkg:test")
+	require.False(ok)
+}
+
+func TestCriticalPath(t *testing.T) {
+	require := require.New(t)
+
+	// //app:build depends on //lib:build and //gen:build,
+	// which both depend on //tools:build.
+	deps := map[string][]string{
+		"//app:build":   {"//lib:build", "//gen:build"},
+		"//lib:build":   {"//tools:build"},
+		"//gen:build":   {"//tools:build"},
+		"//tools:build": {},
+	}
+	dependencies := func(id string) []rules.Rule {
+		result := []rules.Rule{}
+		for _, d := range deps[id] {
+			result = append(result, &rules.Task{IID: d})
+		}
+		return result
+	}
+
+	d := Load(filepath.Join(t.TempDir(), "durations"))
+	d.Set("//app:build", 1*time.Second)
+	d.Set("//lib:build", 2*time.Second)
+	d.Set("//gen:build", 5*time.Second)
+
+	p := CriticalPath("//app:build", dependencies, d)
+	require.Equal(6*time.Second, p.Total)
+	require.Equal([]Step{
+		{ID: "//tools:build", Duration: 0, Known: false},
+		{ID: "//gen:build", Duration: 5 * time.Second, Known: true},
+		{ID: "//app:build", Duration: 1 * time.Second, Known: true},
+	}, p.Steps)
+}
+
+func TestRunsAreRecorded(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStore(filepath.Join(t.TempDir(), "history"))
+
+	first := &Run{Target: "//pkg:build", Tasks: []TaskRun{{ID: "//pkg:build", Status: "executed", Cache: "miss"}}}
+	require.NoError(s.Record(first))
+	require.Equal(1, first.ID)
+
+	second := &Run{Target: "//app:build", ExitCode: 1, Error: "1 task(s) failed"}
+	require.NoError(s.Record(second))
+	require.Equal(2, second.ID)
+
+	runs, err := s.List()
+	require.NoError(err)
+	require.Len(runs, 2)
+	require.Equal("//app:build", runs[0].Target)
+	require.Equal("//pkg:build", runs[1].Target)
+
+	run, err := s.Get(1)
+	require.NoError(err)
+	require.Equal(first.Tasks, run.Tasks)
+
+	_, err = s.Get(3)
+	require.EqualError(err, "run 3 not found")
+}
+
+func TestOldRunsArePruned(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStore(filepath.Join(t.TempDir(), "history"))
+	for i := 0; i < MaxRuns+5; i++ {
+		require.NoError(s.Record(&Run{Target: "//pkg:build"}))
+	}
+
+	runs, err := s.List()
+	require.NoError(err)
+	require.Len(runs, MaxRuns)
+	require.Equal(MaxRuns+5, runs[0].ID)
+	require.Equal(6, runs[len(runs)-1].ID)
+}
@@ -0,0 +1,176 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxRuns is the number of runs kept by a Store,
+// older runs are deleted when a new one is recorded.
+const MaxRuns = 100
+
+// Run is a record of a single `taskgraph run`.
+type Run struct {
+	ID       int           `json:"id"`
+	Target   string        `json:"target"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exitCode"`
+	Error    string        `json:"error,omitempty"`
+	Tasks    []TaskRun     `json:"tasks"`
+}
+
+// TaskRun is the outcome of a task during a run.
+type TaskRun struct {
+	ID       string        `json:"id"`
+	Status   string        `json:"status"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Attempts int           `json:"attempts,omitempty"`
+	// Cache is "hit" when the outputs were restored from the cache,
+	// "miss" when the task had to execute and empty otherwise.
+	Cache string `json:"cache,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Store keeps a record of each run in a directory.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store that keeps runs in dir.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Record saves a run and assigns it the next id.
+func (s *Store) Record(run *Run) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	run.ID = 1
+	if len(ids) > 0 {
+		run.ID = ids[len(ids)-1] + 1
+	}
+
+	b, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL stops concurrent runs from overwriting each other
+	for {
+		f, err := os.OpenFile(s.path(run.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			run.ID++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		break
+	}
+
+	return s.prune()
+}
+
+// List returns the recorded runs, most recent first.
+func (s *Store) List() ([]*Run, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []*Run{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		run, err := s.Get(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// Get returns a single run.
+func (s *Store) Get(id int) (*Run, error) {
+	b, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("run %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run := &Run{}
+	if err := json.Unmarshal(b, run); err != nil {
+		return nil, errors.Wrapf(err, "failed to read run %d", id)
+	}
+
+	return run, nil
+}
+
+func (s *Store) prune() error {
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > MaxRuns {
+		if err := os.Remove(s.path(ids[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ids = ids[1:]
+	}
+
+	return nil
+}
+
+// ids returns the ids of the recorded runs in ascending order.
+func (s *Store) ids() ([]int, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, e := range entries {
+		if id, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json")); err == nil && !e.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+func (s *Store) path(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.json", id))
+}
@@ -3,36 +3,257 @@ package pm
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
 type ProcessManager interface {
-	Start(f func(ctx context.Context) error)
+	Start(f func(ctx context.Context) error, opts ...StartOption)
 	Wait() error
+	// Shutdown stops the processes one at a time in the reverse of
+	// the order they were started. Processes are started after what they
+	// depend on, so a process is stopped before anything it depends on,
+	// but processes that don't depend on each other aren't stopped in
+	// parallel. It returns once every process has exited.
+	Shutdown()
+}
+
+// Restart is when a process is started again after it exits.
+type Restart string
+
+const (
+	RestartNo        Restart = "no"
+	RestartOnFailure Restart = "on-failure"
+	RestartAlways    Restart = "always"
+)
+
+// ParseRestart validates a restart mode from a build file.
+func ParseRestart(s string) (Restart, error) {
+	switch r := Restart(s); r {
+	case RestartNo, RestartOnFailure, RestartAlways:
+		return r, nil
+	}
+	return "", fmt.Errorf("invalid restart %q, expected %q, %q or %q", s, RestartNo, RestartOnFailure, RestartAlways)
+}
+
+// maxBackoff caps the doubling of the backoff between restarts.
+const maxBackoff = time.Minute
+
+// RestartPolicy decides whether a process that
+// exits while the manager is running is restarted.
+type RestartPolicy struct {
+	Restart Restart
+	// Backoff is the delay before the first restart,
+	// it doubles for each restart after that.
+	Backoff time.Duration
+	// MaxRestarts is the number of times the process can be restarted.
+	MaxRestarts int
+}
+
+func (r RestartPolicy) restarts(err error, restarts int) bool {
+	if restarts >= r.MaxRestarts {
+		return false
+	}
+	switch r.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// Exit describes a process that exited.
+type Exit struct {
+	Err error
+	// Restarts is the number of times the process was restarted before it exited.
+	Restarts int
+	// Restarting is true when the process will be started again after Backoff.
+	Restarting bool
+	Backoff    time.Duration
+}
+
+type StartOption func(o *startOptions)
+
+type startOptions struct {
+	ctx        context.Context
+	policy     RestartPolicy
+	onExit     func(e Exit)
+	beforeStop func()
+}
+
+// WithContext stops the process once ctx is done. A process stopped
+// this way, or by Shutdown, isn't restarted and doesn't cause Wait to fail.
+func WithContext(ctx context.Context) StartOption {
+	return func(o *startOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithRestart restarts the process according to the policy.
+func WithRestart(policy RestartPolicy) StartOption {
+	return func(o *startOptions) {
+		o.policy = policy
+	}
+}
+
+// OnExit calls fn every time the process exits.
+func OnExit(fn func(e Exit)) StartOption {
+	return func(o *startOptions) {
+		o.onExit = fn
+	}
+}
+
+// BeforeStop calls fn when the process is stopped by Shutdown,
+// before its context is cancelled.
+func BeforeStop(fn func()) StartOption {
+	return func(o *startOptions) {
+		o.beforeStop = fn
+	}
 }
 
 type pm struct {
 	wg    *errgroup.Group
 	wgctx context.Context
+
+	mu        sync.Mutex
+	processes []*process
+	stopping  bool
+	shutdown  sync.Once
+}
+
+type process struct {
+	cancel     context.CancelFunc
+	beforeStop func()
+	done       chan struct{}
 }
 
+// New creates a process manager that shuts down once ctx
+// is done or any of its processes fails.
 func New(ctx context.Context) ProcessManager {
 	wg, wgctx := errgroup.WithContext(ctx)
-	return &pm{
+	p := &pm{
 		wg:    wg,
 		wgctx: wgctx,
 	}
+
+	go func() {
+		<-wgctx.Done()
+		p.Shutdown()
+	}()
+
+	return p
 }
 
 // Start implements ProcessManager
-func (p *pm) Start(f func(ctx context.Context) error) {
+func (p *pm) Start(f func(ctx context.Context) error, opts ...StartOption) {
+	o := &startOptions{
+		ctx:        context.Background(),
+		policy:     RestartPolicy{Restart: RestartNo},
+		onExit:     func(e Exit) {},
+		beforeStop: func() {},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// processes aren't cancelled by the context of the manager
+	// directly, Shutdown cancels them one at a time instead.
+	ctx, cancel := context.WithCancel(context.Background())
+	proc := &process{
+		cancel:     cancel,
+		beforeStop: o.beforeStop,
+		done:       make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	p.processes = append(p.processes, proc)
+	if p.stopping {
+		cancel()
+	}
+	p.mu.Unlock()
+
 	p.wg.Go(func() error {
-		err := f(p.wgctx)
-		return err
+		defer close(proc.done)
+		defer cancel()
+		go func() {
+			select {
+			case <-o.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// o.ctx is checked as well as ctx, the watcher above may
+		// not have cancelled ctx yet when o.ctx is done.
+		stopped := func() bool {
+			return ctx.Err() != nil || o.ctx.Err() != nil
+		}
+
+		backoff := o.policy.Backoff
+		for restarts := 0; ; restarts++ {
+			err := f(ctx)
+
+			e := Exit{
+				Err:        err,
+				Restarts:   restarts,
+				Restarting: !stopped() && o.policy.restarts(err, restarts),
+				Backoff:    backoff,
+			}
+			o.onExit(e)
+
+			// a process that was stopped on purpose, including
+			// by onExit cancelling o.ctx, hasn't failed
+			if !e.Restarting {
+				if stopped() {
+					return nil
+				}
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	})
+}
+
+// Shutdown implements ProcessManager. The order is by start time,
+// the manager doesn't know the dependencies between processes.
+func (p *pm) Shutdown() {
+	p.shutdown.Do(func() {
+		p.mu.Lock()
+		p.stopping = true
+		processes := append([]*process{}, p.processes...)
+		p.mu.Unlock()
+
+		for i := len(processes) - 1; i >= 0; i-- {
+			processes[i].stop()
+		}
 	})
 }
 
+func (proc *process) stop() {
+	select {
+	case <-proc.done:
+		return
+	default:
+	}
+
+	proc.beforeStop()
+	proc.cancel()
+	<-proc.done
+}
+
 // Wait implements ProcessManager
 func (p *pm) Wait() error {
 	if err := p.wg.Wait(); errors.Is(err, context.Canceled) {
@@ -2,6 +2,8 @@ package pm
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -49,3 +51,176 @@ func TestWaitsForCancel(t *testing.T) {
 	require.NoError(p.Wait())
 	require.True(didrun)
 }
+
+func TestRestartsOnFailure(t *testing.T) {
+	require := require.New(t)
+
+	p := New(context.Background())
+
+	runs := 0
+	exits := []Exit{}
+	p.Start(func(ctx context.Context) error {
+		runs++
+		if runs < 3 {
+			return errors.New("crashed")
+		}
+		return nil
+	}, WithRestart(RestartPolicy{
+		Restart:     RestartOnFailure,
+		Backoff:     time.Millisecond,
+		MaxRestarts: 5,
+	}), OnExit(func(e Exit) {
+		exits = append(exits, e)
+	}))
+
+	require.NoError(p.Wait())
+	require.Equal(3, runs)
+	require.Len(exits, 3)
+	require.True(exits[0].Restarting)
+	require.Equal(2*time.Millisecond, exits[1].Backoff)
+	require.False(exits[2].Restarting)
+}
+
+func TestRestartsAreLimited(t *testing.T) {
+	require := require.New(t)
+
+	p := New(context.Background())
+
+	runs := 0
+	p.Start(func(ctx context.Context) error {
+		runs++
+		return nil
+	}, WithRestart(RestartPolicy{
+		Restart:     RestartAlways,
+		MaxRestarts: 2,
+	}))
+
+	require.NoError(p.Wait())
+	require.Equal(3, runs)
+}
+
+func TestStoppedProcessIsNotRestarted(t *testing.T) {
+	require := require.New(t)
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	p := New(context.Background())
+
+	runs := 0
+	p.Start(func(ctx context.Context) error {
+		runs++
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithContext(ctx), WithRestart(RestartPolicy{
+		Restart:     RestartAlways,
+		MaxRestarts: 5,
+	}))
+
+	stop()
+
+	require.NoError(p.Wait())
+	require.Equal(1, runs)
+}
+
+func TestParseRestart(t *testing.T) {
+	require := require.New(t)
+
+	r, err := ParseRestart("on-failure")
+	require.NoError(err)
+	require.Equal(RestartOnFailure, r)
+
+	_, err = ParseRestart("sometimes")
+	require.EqualError(err, `invalid restart "sometimes", expected "no", "on-failure" or "always"`)
+}
+
+func TestShutdownInReverseOrder(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx)
+
+	var mu sync.Mutex
+	events := []string{}
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	for _, name := range []string{"db", "api", "web"} {
+		name := name
+		p.Start(func(ctx context.Context) error {
+			<-ctx.Done()
+			// give a process stopped too early the chance to be recorded out of order
+			<-time.After(10 * time.Millisecond)
+			record("exited " + name)
+			return ctx.Err()
+		}, BeforeStop(func() {
+			record("stopping " + name)
+		}))
+	}
+
+	cancel()
+
+	require.NoError(p.Wait())
+	require.Equal([]string{
+		"stopping web", "exited web",
+		"stopping api", "exited api",
+		"stopping db", "exited db",
+	}, events)
+}
+
+func TestShutdownAfterFailure(t *testing.T) {
+	require := require.New(t)
+
+	p := New(context.Background())
+
+	stopped := false
+	p.Start(func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = true
+		return ctx.Err()
+	})
+	p.Start(func(ctx context.Context) error {
+		return errors.New("crashed")
+	})
+
+	require.EqualError(p.Wait(), "crashed")
+	require.True(stopped)
+}
+
+func TestProcessStoppedOnExitDoesNotStopOthers(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx)
+
+	stopped := make(chan struct{})
+	p.Start(func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return ctx.Err()
+	})
+
+	// like a process that exits before it is ready,
+	// it stops itself when it exits with an error
+	processctx, stop := context.WithCancel(context.Background())
+	p.Start(func(ctx context.Context) error {
+		return errors.New("exited before ready")
+	}, WithContext(processctx), OnExit(func(e Exit) {
+		stop()
+	}))
+
+	require.Never(func() bool {
+		select {
+		case <-stopped:
+			return true
+		default:
+			return false
+		}
+	}, 100*time.Millisecond, 10*time.Millisecond)
+
+	cancel()
+
+	require.NoError(p.Wait())
+}
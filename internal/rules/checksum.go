@@ -2,8 +2,9 @@ package rules
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -11,27 +12,93 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"taskgraph/internal"
+	"taskgraph/internal/cache"
+	"taskgraph/internal/filehash"
 	"taskgraph/internal/hostfs"
 
 	"github.com/bmatcuk/doublestar/v4"
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 )
 
 type Checksum struct {
 	Inner        Rule
 	WorkspaceDir string
+	Cache        *cache.Cache
+	Hashes       *filehash.Cache
 	Stdout       io.Writer
+
+	// Upstream are the rules this task depends on. Their fingerprints
+	// are included in the checksum so that changes propagate through
+	// the graph.
+	Upstream []Rule
+
+	fingerprint string
+	status      Status
+}
+
+// Fingerprinter is implemented by rules that can describe
+// the state of their outputs after they have been executed.
+type Fingerprinter interface {
+	Fingerprint() string
+}
+
+// Definer is implemented by rules whose attributes affect
+// their outputs, such as the commands they run.
+type Definer interface {
+	// Definition returns the attributes that affect the outputs.
+	Definition() []string
+	// Environment returns the names of the environment variables
+	// that affect the outputs.
+	Environment() []string
+}
+
+// record is the state stored under .taskgraph/ for each task
+// after it has been executed successfully.
+type record struct {
+	Inputs     string            `json:"inputs"`
+	Files      map[string]string `json:"files,omitempty"`
+	Deps       map[string]string `json:"deps,omitempty"`
+	Definition string            `json:"definition,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	Outputs    string            `json:"outputs"`
+}
+
+// key identifies everything that was used to produce the outputs.
+func (r *record) key() string {
+	h := sha256.New()
+	io.WriteString(h, r.Inputs)
+
+	for _, id := range sortedKeys(r.Deps) {
+		io.WriteString(h, id)
+		io.WriteString(h, r.Deps[id])
+	}
+
+	io.WriteString(h, r.Definition)
+
+	for _, name := range sortedKeys(r.Env) {
+		io.WriteString(h, name)
+		io.WriteString(h, r.Env[name])
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 // Execute implements Rule
 func (c *Checksum) Execute(ctx context.Context) error {
+	c.status = StatusExecuted
+
 	if len(c.Inner.Inputs()) == 0 {
-		return c.Inner.Execute(ctx)
+		if err := c.Inner.Execute(ctx); err != nil {
+			return err
+		}
+		return c.updateFingerprint()
 	}
 
-	current, err := checksum(hostfs.FS(), c.Inner.Getwd(), c.Inputs(), []string{})
+	current, err := c.current()
 	if err != nil {
 		return err
 	}
@@ -41,25 +108,234 @@ func (c *Checksum) Execute(ctx context.Context) error {
 		return err
 	}
 
-	// TODO: need to do something with the Outputs()
-	// so that if the outputs are deleted then we know we
-	// need to rebuild.
-	// perhaps we can include outputs in the hash
-
-	if current != previous {
-		if err := c.Inner.Execute(ctx); err != nil {
+	if previous.Inputs != "" && current.key() == previous.key() {
+		// the outputs are checked as well so that deleting or
+		// modifying them causes the task to be rebuilt.
+		outputs, err := c.outputs()
+		if err != nil {
 			return err
 		}
-		if err := c.store(current); err != nil {
-			logrus.Error(errors.Wrapf(err, "failed to store checksum for task %s", c.ID()))
+
+		if outputs == previous.Outputs {
+			c.fingerprint = outputs
+			c.status = StatusUpToDate
+			fmt.Fprintf(c.Stdout, "%s is up-to-date\n", c.Inner.ID())
+			return nil
+		}
+	}
+
+	if c.cacheable() {
+		restored, err := c.Cache.Restore(ctx, c.cacheKey(current.key()), c.Getwd())
+		if err != nil {
+			logrus.Warn(errors.Wrapf(err, "failed to restore task %s from cache", c.ID()))
+		} else if restored {
+			if err := c.commit(current); err != nil {
+				return err
+			}
+			c.status = StatusCached
+			fmt.Fprintf(c.Stdout, "%s was restored from cache\n", c.Inner.ID())
+			return nil
+		}
+	}
+
+	if err := c.Inner.Execute(ctx); err != nil {
+		return err
+	}
+
+	if err := c.commit(current); err != nil {
+		return err
+	}
+
+	if c.cacheable() {
+		files, err := glob(hostfs.FS(), c.Getwd(), c.Outputs(), []string{})
+		if err == nil {
+			err = c.Cache.Store(ctx, c.cacheKey(current.key()), c.Getwd(), files)
+		}
+		if err != nil {
+			logrus.Warn(errors.Wrapf(err, "failed to store outputs of task %s in cache", c.ID()))
 		}
-		return nil
 	}
 
-	fmt.Fprintf(c.Stdout, "%s is up-to-date\n", c.Inner.ID())
 	return nil
 }
 
+// Status implements Reporter
+func (c *Checksum) Status() Status {
+	if c.status == StatusExecuted && c.Attempts() > 1 {
+		return StatusFlaky
+	}
+	return c.status
+}
+
+// Requires implements Constrained
+func (c *Checksum) Requires() []Resource {
+	if r, ok := c.Inner.(Constrained); ok {
+		return r.Requires()
+	}
+	return nil
+}
+
+// Attempts implements Retrier
+func (c *Checksum) Attempts() int {
+	if r, ok := c.Inner.(Retrier); ok {
+		return r.Attempts()
+	}
+	return 0
+}
+
+// Fingerprint implements Fingerprinter. It is only
+// available after the task has been executed.
+func (c *Checksum) Fingerprint() string {
+	return c.fingerprint
+}
+
+// current returns the record of the inputs of the task
+// as they are right now.
+func (c *Checksum) current() (*record, error) {
+	deps := map[string]string{}
+	for _, u := range c.Upstream {
+		if f, ok := u.(Fingerprinter); ok {
+			deps[u.ID()] = f.Fingerprint()
+		}
+	}
+
+	return c.currentWithDeps(deps)
+}
+
+func (c *Checksum) currentWithDeps(deps map[string]string) (*record, error) {
+	files, err := manifest(hostfs.FS(), c.Hashes, c.Inner.Getwd(), c.Inputs(), c.Excludes())
+	if err != nil {
+		return nil, err
+	}
+
+	// the working directory is relative to the workspace so
+	// that checksums are the same on every machine.
+	wd, err := filepath.Rel(c.WorkspaceDir, c.Getwd())
+	if err != nil {
+		return nil, err
+	}
+	definition := []string{filepath.ToSlash(wd)}
+
+	env := map[string]string{}
+	if d, ok := c.Inner.(Definer); ok {
+		definition = append(definition, d.Definition()...)
+		for _, name := range d.Environment() {
+			// values are hashed so that secrets aren't
+			// written to the .taskgraph directory.
+			env[name] = digest(os.Getenv(name))
+		}
+	}
+
+	return &record{
+		Inputs:     summarise(files),
+		Files:      files,
+		Deps:       deps,
+		Definition: digest(strings.Join(definition, "\x00")),
+		Env:        env,
+	}, nil
+}
+
+// commit records the current outputs of the task against
+// the inputs that produced them.
+func (c *Checksum) commit(r *record) error {
+	if err := c.updateFingerprint(); err != nil {
+		return err
+	}
+
+	r.Outputs = c.fingerprint
+	if err := c.store(r); err != nil {
+		logrus.Error(errors.Wrapf(err, "failed to store checksum for task %s", c.ID()))
+	}
+
+	return nil
+}
+
+func (c *Checksum) updateFingerprint() error {
+	outputs, err := c.outputs()
+	if err != nil {
+		return err
+	}
+
+	c.fingerprint = outputs
+	return nil
+}
+
+// outputs returns the checksum of the current outputs
+// of the task or "" if it doesn't declare any.
+func (c *Checksum) outputs() (string, error) {
+	if len(c.Outputs()) == 0 {
+		return "", nil
+	}
+
+	return checksum(hostfs.FS(), c.Hashes, c.Inner.Getwd(), c.Outputs(), []string{})
+}
+
+// cacheKey is the key the outputs of the task are stored under in
+// the cache. It includes the id of the task so that tasks with the
+// same inputs but different commands never share outputs.
+func (c *Checksum) cacheKey(inputs string) string {
+	h := sha256.New()
+	io.WriteString(h, c.ID())
+	io.WriteString(h, inputs)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// cacheable reports whether the outputs of the task should
+// be stored in the cache.
+func (c *Checksum) cacheable() bool {
+	return c.ownsOutputs() && c.Cache != nil && len(c.Outputs()) > 0
+}
+
+// ownsOutputs reports whether the outputs of the task are produced
+// by it. The outputs of a filegroup are its source files.
+func (c *Checksum) ownsOutputs() bool {
+	_, ok := c.Inner.(*Filegroup)
+	return !ok
+}
+
+// Clean deletes the outputs of the task and its checksum record
+// and returns the paths that were deleted. If dryRun is set then
+// nothing is deleted.
+func (c *Checksum) Clean(dryRun bool) ([]string, error) {
+	outputs := []string{}
+	if c.ownsOutputs() {
+		files, err := glob(hostfs.FS(), c.Getwd(), c.Outputs(), []string{})
+		if err != nil {
+			return nil, err
+		}
+		outputs = files
+	}
+
+	paths := append([]string{}, outputs...)
+	_, err := os.Stat(c.path())
+	hasRecord := err == nil
+	if hasRecord {
+		paths = append(paths, c.path())
+	}
+
+	if dryRun {
+		return paths, nil
+	}
+
+	for _, path := range outputs {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		removeEmptyDirs(filepath.Dir(path), c.Getwd())
+	}
+
+	// the record is cleaned up separately so that
+	// the state directory itself is never removed.
+	if hasRecord {
+		if err := os.Remove(c.path()); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		removeEmptyDirs(filepath.Dir(c.path()), filepath.Join(c.WorkspaceDir, internal.StateDir))
+	}
+
+	return paths, nil
+}
+
 // Dependencies implements Rule
 func (c *Checksum) Dependencies() []string {
 	return c.Inner.Dependencies()
@@ -80,23 +356,111 @@ func (c *Checksum) Outputs() []string {
 	return c.Inner.Outputs()
 }
 
+// Excludes implements Rule
+func (c *Checksum) Excludes() []string {
+	return c.Inner.Excludes()
+}
+
 func (c *Checksum) Getwd() string {
 	return c.Inner.Getwd()
 }
 
+// Files returns the paths of the checksum record and the
+// cached outputs stored for the last successful execution.
+func (c *Checksum) Files() ([]string, error) {
+	r, err := c.load()
+	if err != nil || r.Inputs == "" {
+		return []string{}, err
+	}
+
+	files := []string{c.path()}
+
+	if c.cacheable() {
+		x, err := c.Cache.Files(c.cacheKey(r.key()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, x...)
+	}
+
+	return files, nil
+}
+
 var _ Rule = &Checksum{}
+var _ Reporter = &Checksum{}
+var _ Fingerprinter = &Checksum{}
+var _ Retrier = &Checksum{}
+var _ Constrained = &Checksum{}
+
+func checksum(fs fs.FS, hashes *filehash.Cache, cwd string, includes []string, excludes []string) (string, error) {
+	files, err := manifest(fs, hashes, cwd, includes, excludes)
+	if err != nil {
+		return "", err
+	}
+
+	return summarise(files), nil
+}
+
+// manifest returns the digest of every file matching the patterns
+// keyed by the path of the file relative to cwd. Paths are relative
+// so that moving the workspace doesn't change the checksum.
+func manifest(fs fs.FS, hashes *filehash.Cache, cwd string, includes []string, excludes []string) (map[string]string, error) {
+	paths, err := glob(fs, cwd, includes, excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	for _, path := range paths {
+		d, err := hashes.Digest(path)
+		if err != nil {
+			return nil, err
+		}
+
+		relpath, _ := filepath.Rel(cwd, path)
+		files[filepath.ToSlash(relpath)] = d
+	}
+
+	return files, nil
+}
+
+// summarise combines the digests of a manifest into a single checksum.
+func summarise(files map[string]string) string {
+	h := sha256.New()
+	for _, path := range sortedKeys(files) {
+		fmt.Fprintf(h, "%s\x00%s\x00", path, files[path])
+	}
 
-func checksum(fs fs.FS, cwd string, includes []string, excludes []string) (string, error) {
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func digest(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// glob returns the sorted list of files matching the includes
+// patterns that don't match any of the excludes patterns.
+// Includes patterns starting with "!" are treated as excludes.
+func glob(fs fs.FS, cwd string, includes []string, excludes []string) ([]string, error) {
 	paths := mapset.NewSet[string]()
 
+	negated := lo.FilterMap(includes, func(pattern string, i int) (string, bool) {
+		return strings.TrimPrefix(pattern, "!"), strings.HasPrefix(pattern, "!")
+	})
+	excludes = append(negated, excludes...)
+
 	for _, source := range includes {
+		if strings.HasPrefix(source, "!") {
+			continue
+		}
+
 		results, err := doublestar.Glob(fs, filepath.Join(cwd, source))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		for _, path := range results {
-			if f, _ := os.Stat(path); !f.IsDir() {
+			if f, err := os.Stat(path); err == nil && !f.IsDir() {
 				relpath, _ := filepath.Rel(cwd, path)
 				if !excluded(excludes, relpath) {
 					paths.Add(path)
@@ -108,47 +472,59 @@ func checksum(fs fs.FS, cwd string, includes []string, excludes []string) (strin
 	p := paths.ToSlice()
 	sort.Strings(p)
 
-	h := crc32.NewIEEE()
-	for _, path := range p {
-		if _, err := io.WriteString(h, path); err != nil {
-			return "", err
-		}
+	return p, nil
+}
 
-		f, err := fs.Open(path)
-		if err != nil {
-			return "", err
+// removeEmptyDirs removes dir and its parents up to
+// root for as long as they are empty.
+func removeEmptyDirs(dir string, root string) {
+	dir, root = filepath.Clean(dir), filepath.Clean(root)
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
 		}
-		if _, err := io.Copy(h, f); err != nil {
-			f.Close()
-			return "", err
-		}
-		f.Close()
+		dir = filepath.Dir(dir)
 	}
+}
 
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func excluded(patterns []string, path string) bool {
 	for _, pattern := range patterns {
-		if match, _ := doublestar.Match(filepath.ToSlash(pattern), filepath.ToSlash(path)); match {
+		if match, _ := doublestar.Match(filepath.ToSlash(filepath.Clean(pattern)), filepath.ToSlash(path)); match {
 			return true
 		}
 	}
 	return false
 }
 
-func (t *Checksum) load() (string, error) {
+func (t *Checksum) load() (*record, error) {
 	b, err := ioutil.ReadFile(t.path())
 	if os.IsNotExist(err) {
-		return "", nil
+		return &record{}, nil
 	} else if err != nil {
-		return "", errors.Wrap(err, "failed to load task checksum")
+		return nil, errors.Wrap(err, "failed to load task checksum")
 	}
 
-	return strings.TrimSpace(string(b)), nil
+	r := &record{}
+	if err := json.Unmarshal(b, r); err != nil {
+		// checksums written by older versions are treated
+		// as missing so that the task is rebuilt.
+		logrus.Debugf("ignoring unreadable checksum for task %s: %s", t.ID(), err)
+		return &record{}, nil
+	}
+
+	return r, nil
 }
 
-func (t *Checksum) store(c string) error {
+func (t *Checksum) store(r *record) error {
 	path := t.path()
 
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
@@ -157,7 +533,12 @@ func (t *Checksum) store(c string) error {
 		}
 	}
 
-	if err := ioutil.WriteFile(path, []byte(c), 0644); err != nil {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode task checksum")
+	}
+
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
 		return errors.Wrap(err, "failed to write task checksum")
 
 	}
@@ -166,5 +547,5 @@ func (t *Checksum) store(c string) error {
 }
 
 func (t *Checksum) path() string {
-	return filepath.Join(t.WorkspaceDir, ".taskgraph", t.ID())
+	return filepath.Join(t.WorkspaceDir, internal.StateDir, t.ID())
 }
@@ -0,0 +1,314 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"taskgraph/internal"
+	"taskgraph/internal/cache"
+	"taskgraph/internal/hostfs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type countingRule struct {
+	Task
+	executions int
+}
+
+func (r *countingRule) Execute(ctx context.Context) error {
+	r.executions++
+	return ioutil.WriteFile(filepath.Join(r.Cwd, "out.txt"), []byte("output"), 0644)
+}
+
+func newCountingChecksum(t *testing.T) (*Checksum, *countingRule) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("input"), 0644))
+
+	inner := &countingRule{
+		Task: Task{
+			IID:  "//pkg:build",
+			Srcs: []string{"in.txt"},
+			Outs: []string{"out.txt"},
+			Cwd:  dir,
+		},
+	}
+
+	return &Checksum{
+		Inner:        inner,
+		WorkspaceDir: dir,
+		Stdout:       ioutil.Discard,
+	}, inner
+}
+
+func TestChecksumUpToDate(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(1, inner.executions)
+}
+
+func TestChecksumInputChanged(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(ioutil.WriteFile(filepath.Join(inner.Cwd, "in.txt"), []byte("changed"), 0644))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumOutputDeleted(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(os.Remove(filepath.Join(inner.Cwd, "out.txt")))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumOutputModified(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(ioutil.WriteFile(filepath.Join(inner.Cwd, "out.txt"), []byte("tampered"), 0644))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumRestoresFromCache(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("input"), 0644))
+
+	c := &Checksum{
+		Inner: &Task{
+			IID:    "//pkg:build",
+			Srcs:   []string{"in.txt"},
+			Outs:   []string{"out.txt"},
+			Cmds:   []string{"echo built >> out.txt"},
+			Cwd:    dir,
+			Stdout: ioutil.Discard,
+			Stderr: ioutil.Discard,
+		},
+		WorkspaceDir: dir,
+		Cache:        cache.New(filepath.Join(dir, ".taskgraph", "cache")),
+		Stdout:       ioutil.Discard,
+	}
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(os.Remove(filepath.Join(dir, "out.txt")))
+	require.NoError(c.Execute(context.Background()))
+
+	// the command appends to the file so running it
+	// again would produce a different output.
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	require.NoError(err)
+	require.Equal("built\n", string(b))
+}
+
+func TestChecksumCacheIsPerTask(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "in.txt"), []byte("input"), 0644))
+
+	newTask := func(id string, cmd string) *Checksum {
+		return &Checksum{
+			Inner: &Task{
+				IID:    id,
+				Srcs:   []string{"in.txt"},
+				Outs:   []string{"out.txt"},
+				Cmds:   []string{cmd},
+				Cwd:    dir,
+				Stdout: ioutil.Discard,
+				Stderr: ioutil.Discard,
+			},
+			WorkspaceDir: dir,
+			Cache:        cache.New(filepath.Join(dir, ".taskgraph", "cache")),
+			Stdout:       ioutil.Discard,
+		}
+	}
+
+	build := newTask("//pkg:build", "echo built > out.txt")
+	test := newTask("//pkg:test", "echo tested > out.txt")
+
+	require.NoError(build.Execute(context.Background()))
+	require.NoError(os.Remove(filepath.Join(dir, "out.txt")))
+	require.NoError(test.Execute(context.Background()))
+
+	// the same inputs mustn't restore the outputs of another task
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	require.NoError(err)
+	require.Equal("tested\n", string(b))
+}
+
+type fixedFingerprint struct {
+	Task
+	fingerprint string
+}
+
+func (f *fixedFingerprint) Fingerprint() string {
+	return f.fingerprint
+}
+
+func TestChecksumDependencyChanged(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	dep := &fixedFingerprint{Task: Task{IID: "//pkg:dep"}, fingerprint: "a"}
+	c.Upstream = []Rule{dep}
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(1, inner.executions)
+
+	dep.fingerprint = "b"
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumCommandChanged(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	inner.Cmds = []string{"dotnet build"}
+
+	require.NoError(c.Execute(context.Background()))
+	inner.Cmds = []string{"dotnet build -c Release"}
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumEnvironmentChanged(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	inner.EnvInputs = []string{"TASKGRAPH_TEST_CONFIGURATION"}
+
+	t.Setenv("TASKGRAPH_TEST_CONFIGURATION", "Debug")
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(1, inner.executions)
+
+	t.Setenv("TASKGRAPH_TEST_CONFIGURATION", "Release")
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(2, inner.executions)
+}
+
+func TestChecksumWithoutOutputsUpToDate(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	inner.Outs = []string{}
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(1, inner.executions)
+}
+
+func TestChecksumExplain(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+
+	reasons, err := c.Explain()
+	require.NoError(err)
+	require.Equal([]string{"the task has not been run before"}, reasons)
+
+	require.NoError(c.Execute(context.Background()))
+
+	reasons, err = c.Explain()
+	require.NoError(err)
+	require.Empty(reasons)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(inner.Cwd, "in.txt"), []byte("changed"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(inner.Cwd, "new.txt"), []byte("new"), 0644))
+	inner.Srcs = []string{"*.txt"}
+	inner.Outs = []string{"out/*"}
+	inner.Cmds = []string{"dotnet build -c Release"}
+
+	reasons, err = c.Explain()
+	require.NoError(err)
+	require.Equal([]string{
+		"input in.txt was modified",
+		"input new.txt was added",
+		"input out.txt was added",
+		"the task definition changed (cmds, outs or working directory)",
+	}, reasons)
+}
+
+func TestChecksumClean(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	require.NoError(c.Execute(context.Background()))
+
+	paths, err := c.Clean(true)
+	require.NoError(err)
+	require.Len(paths, 2)
+	require.FileExists(filepath.Join(inner.Cwd, "out.txt"))
+
+	_, err = c.Clean(false)
+	require.NoError(err)
+	require.NoFileExists(filepath.Join(inner.Cwd, "out.txt"))
+	require.NoFileExists(c.path())
+	require.DirExists(filepath.Join(inner.Cwd, internal.StateDir))
+	require.FileExists(filepath.Join(inner.Cwd, "in.txt"))
+}
+
+func TestRemoveEmptyDirsStaysUnderRoot(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(dir, "output", "sub"), 0755))
+
+	// "output" starts with "out" but isn't inside it
+	removeEmptyDirs(filepath.Join(dir, "output", "sub"), filepath.Join(dir, "out"))
+	require.DirExists(filepath.Join(dir, "output", "sub"))
+
+	removeEmptyDirs(filepath.Join(dir, "output", "sub"), dir)
+	require.NoDirExists(filepath.Join(dir, "output"))
+	require.DirExists(dir)
+}
+
+func TestGlobExcludes(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	for _, f := range []string{"a.cs", "obj/b.cs", "bin/c.cs", "src/d.cs"} {
+		require.NoError(os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755))
+		require.NoError(ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644))
+	}
+
+	paths, err := glob(hostfs.FS(), dir, []string{"./**/*.cs", "!./bin/**"}, []string{"./obj/**"})
+	require.NoError(err)
+	require.Equal([]string{
+		filepath.Join(dir, "a.cs"),
+		filepath.Join(dir, "src", "d.cs"),
+	}, paths)
+}
+
+func TestChecksumIgnoresExcludedInputs(t *testing.T) {
+	require := require.New(t)
+
+	c, inner := newCountingChecksum(t)
+	inner.Srcs = []string{"*.txt"}
+	inner.Exclude = []string{"generated.txt", "out.txt"}
+
+	require.NoError(c.Execute(context.Background()))
+	require.NoError(ioutil.WriteFile(filepath.Join(inner.Cwd, "generated.txt"), []byte("generated"), 0644))
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(1, inner.executions)
+}
@@ -6,7 +6,40 @@ type Rule interface {
 	ID() string
 	Inputs() []string
 	Outputs() []string
+	// Excludes are patterns for files that aren't part of the Inputs.
+	Excludes() []string
 	Dependencies() []string
 	Getwd() string
 	Execute(ctx context.Context) error
 }
+
+// Status describes how a rule was satisfied by Execute.
+type Status string
+
+const (
+	StatusExecuted Status = "executed"
+	StatusUpToDate Status = "up-to-date"
+	StatusCached   Status = "cached"
+	// StatusFlaky is an execution that only succeeded after retrying.
+	StatusFlaky Status = "flaky"
+)
+
+// Reporter is implemented by rules that know how
+// they were satisfied after they have been executed.
+type Reporter interface {
+	Status() Status
+}
+
+// Constrained is implemented by rules that use
+// resources shared with other rules while they execute.
+type Constrained interface {
+	Requires() []Resource
+}
+
+// Retrier is implemented by rules that can be attempted
+// more than once before they succeed or fail.
+type Retrier interface {
+	// Attempts is the number of times the rule was
+	// attempted the last time it was executed.
+	Attempts() int
+}
@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Explain returns the reasons the task would be executed by the
+// next run. An empty list means the task is up-to-date.
+//
+// Dependencies are assumed to be up-to-date, their current outputs
+// are compared with the outputs recorded when this task last ran.
+// Callers report the dependencies that will run themselves.
+func (c *Checksum) Explain() ([]string, error) {
+	if len(c.Inner.Inputs()) == 0 {
+		return []string{"the task has no srcs so it always runs"}, nil
+	}
+
+	previous, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if previous.Inputs == "" {
+		return []string{"the task has not been run before"}, nil
+	}
+
+	deps := map[string]string{}
+	for _, u := range c.Upstream {
+		if x, ok := u.(*Checksum); ok {
+			f, err := x.outputs()
+			if err != nil {
+				return nil, err
+			}
+			deps[u.ID()] = f
+		} else if x, ok := u.(Fingerprinter); ok {
+			deps[u.ID()] = x.Fingerprint()
+		}
+	}
+
+	current, err := c.currentWithDeps(deps)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := []string{}
+
+	if current.Inputs != previous.Inputs {
+		if previous.Files == nil {
+			// records written by older versions don't have a manifest
+			reasons = append(reasons, "the inputs changed")
+		} else {
+			reasons = append(reasons, diff("input", previous.Files, current.Files)...)
+		}
+	}
+
+	reasons = append(reasons, diff("dependency", previous.Deps, current.Deps)...)
+
+	if current.Definition != previous.Definition {
+		reasons = append(reasons, "the task definition changed (cmds, outs or working directory)")
+	}
+
+	reasons = append(reasons, diff("environment variable", previous.Env, current.Env)...)
+
+	if len(reasons) == 0 {
+		outputs, err := c.outputs()
+		if err != nil {
+			return nil, err
+		}
+		if outputs != previous.Outputs {
+			reasons = append(reasons, "the outputs were deleted or modified")
+		}
+	}
+
+	return reasons, nil
+}
+
+func diff(kind string, previous map[string]string, current map[string]string) []string {
+	reasons := []string{}
+
+	for k, v := range current {
+		if p, ok := previous[k]; !ok {
+			reasons = append(reasons, fmt.Sprintf("%s %s was added", kind, k))
+		} else if p != v {
+			reasons = append(reasons, fmt.Sprintf("%s %s was modified", kind, k))
+		}
+	}
+
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			reasons = append(reasons, fmt.Sprintf("%s %s was removed", kind, k))
+		}
+	}
+
+	sort.Strings(reasons)
+	return reasons
+}
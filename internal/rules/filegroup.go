@@ -3,9 +3,10 @@ package rules
 import "context"
 
 type Filegroup struct {
-	IID  string
-	Srcs []string
-	Cwd  string
+	IID     string
+	Srcs    []string
+	Exclude []string
+	Cwd     string
 }
 
 // Execute implements Rule
@@ -25,7 +26,16 @@ func (f *Filegroup) Inputs() []string {
 
 // Outputs implements Rule
 func (f *Filegroup) Outputs() []string {
-	return f.Srcs
+	outputs := append([]string{}, f.Srcs...)
+	for _, e := range f.Exclude {
+		outputs = append(outputs, "!"+e)
+	}
+	return outputs
+}
+
+// Excludes implements Rule
+func (f *Filegroup) Excludes() []string {
+	return f.Exclude
 }
 
 func (t *Filegroup) ID() string {
@@ -0,0 +1,98 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"taskgraph/internal/execext"
+	"time"
+)
+
+// DefaultReadyInterval is how often a process is
+// probed when it doesn't set a ready interval.
+const DefaultReadyInterval = 250 * time.Millisecond
+
+// probeTimeout limits a single attempt of a probe
+// so that a hung connection doesn't stall polling.
+const probeTimeout = 5 * time.Second
+
+// Probe checks whether a process is ready, returning
+// an error describing why it isn't.
+type Probe func(ctx context.Context) error
+
+// HTTPProbe succeeds once a GET request to the url
+// responds with a 2xx or 3xx status code.
+func HTTPProbe(url string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 400 {
+			return fmt.Errorf("GET %s: %s", url, res.Status)
+		}
+
+		return nil
+	}
+}
+
+// TCPProbe succeeds once a connection to the address can be opened.
+func TCPProbe(address string) Probe {
+	return func(ctx context.Context) error {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// CommandProbe succeeds once the shell command exits with 0.
+func CommandProbe(cmd string, dir string) Probe {
+	return func(ctx context.Context) error {
+		return execext.RunCommand(ctx, cmd, &execext.RunCommandOptions{
+			Env:    os.Environ(),
+			Dir:    dir,
+			Stdout: ioutil.Discard,
+			Stderr: ioutil.Discard,
+		})
+	}
+}
+
+// Poll runs the probes every interval until they all succeed.
+// It returns false if the context is done first.
+func Poll(ctx context.Context, interval time.Duration, probes ...Probe) bool {
+	for {
+		if ready(ctx, probes) {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+func ready(ctx context.Context, probes []Probe) bool {
+	for _, probe := range probes {
+		ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		err := probe(ctx)
+		cancel()
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProbe(t *testing.T) {
+	require := require.New(t)
+
+	healthy := atomic.Bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	probe := HTTPProbe(server.URL)
+	require.EqualError(probe(context.Background()), "GET "+server.URL+": 503 Service Unavailable")
+
+	healthy.Store(true)
+	require.NoError(probe(context.Background()))
+}
+
+func TestTCPProbe(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	address := l.Addr().String()
+
+	require.NoError(TCPProbe(address)(context.Background()))
+
+	require.NoError(l.Close())
+	require.Error(TCPProbe(address)(context.Background()))
+}
+
+func TestCommandProbe(t *testing.T) {
+	require := require.New(t)
+
+	require.NoError(CommandProbe("true", t.TempDir())(context.Background()))
+	require.Error(CommandProbe("false", t.TempDir())(context.Background()))
+}
+
+func TestPollWaitsUntilReady(t *testing.T) {
+	require := require.New(t)
+
+	attempts := 0
+	probe := func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+
+	require.True(Poll(context.Background(), time.Millisecond, probe))
+	require.Equal(3, attempts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	require.False(Poll(ctx, time.Millisecond, CommandProbe("false", t.TempDir())))
+}
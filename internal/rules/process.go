@@ -3,12 +3,15 @@ package rules
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
 	"taskgraph/internal/execext"
 	"taskgraph/internal/pm"
+	"taskgraph/internal/trace"
+	"time"
 )
 
 type Process struct {
@@ -17,6 +20,35 @@ type Process struct {
 	Cmds  []string
 	Ready string
 
+	// ReadyHTTP, ReadyTCP and ReadyCmd are probes polled every
+	// ReadyInterval, the process is ready once they all succeed.
+	ReadyHTTP     string
+	ReadyTCP      string
+	ReadyCmd      string
+	ReadyInterval time.Duration
+
+	// Timeout stops the process if it isn't ready in time, 0 means no timeout.
+	Timeout time.Duration
+	// ReadyTimeout is how long the process has to become ready. It takes
+	// precedence over Timeout and the default timeout of the run when set.
+	ReadyTimeout time.Duration
+
+	// Resources are held until the process is ready, not until it stops,
+	// so they only limit how many processes start at the same time.
+	Resources []Resource
+
+	// Restart decides whether the process is started
+	// again when it exits, by default it isn't.
+	Restart pm.RestartPolicy
+
+	// StopCmds run when the process is shut down, before it is
+	// interrupted. StopTimeout limits how long the stop commands and
+	// then the process have to exit before the process is killed.
+	// Processes are stopped one at a time, so the stop timeouts of
+	// every process add up when shutting down.
+	StopCmds    []string
+	StopTimeout time.Duration
+
 	Cwd    string
 	Stdout io.Writer
 	Stderr io.Writer
@@ -27,42 +59,238 @@ func (p *Process) Dependencies() []string {
 	return p.Deps
 }
 
+// NotReadyError is returned when a process exits
+// or times out before it becomes ready.
+type NotReadyError struct {
+	ID     string
+	Reason string
+	Err    error
+	// Output is the last lines the process wrote to stdout and stderr.
+	Output []string
+}
+
+func (e *NotReadyError) Error() string {
+	msg := e.Reason
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+
+	if len(e.Output) == 0 {
+		return msg + " (no output)"
+	}
+
+	return fmt.Sprintf("%s, last output:\n  %s", msg, strings.Join(e.Output, "\n  "))
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Err
+}
+
+// tailLines is the number of lines of output included in a NotReadyError.
+const tailLines = 10
+
 // Execute implements Rule
 func (p *Process) Execute(ctx context.Context) error {
-	done := make(chan bool, 1)
-
 	processManager := ctx.Value("pm.ProcessManager").(pm.ProcessManager)
+	recorder, _ := ctx.Value("trace.Recorder").(*trace.Recorder)
+
+	output := newTail(tailLines)
+	stdout, stderr := output.Stream(), output.Stream()
+
+	// stopping the process uses the same interrupt-then-kill
+	// logic as any other cancelled command.
+	processctx, stop := context.WithCancel(context.Background())
+
+	// ready is closed the first time the process becomes ready and
+	// notReady receives the last exit if it never did.
+	ready := make(chan struct{})
+	notReady := make(chan pm.Exit, 1)
+	attempts := 0
+
+	attempt := func(ctx context.Context) error {
+		attempts++
+		restarted := attempts > 1
 
-	processManager.Start(func(ctx context.Context) error {
 		pr, w := io.Pipe()
-		r := io.TeeReader(pr, p.Stdout)
+		r := io.TeeReader(pr, io.MultiWriter(p.Stdout, stdout))
+
+		probectx, cancelProbes := context.WithCancel(ctx)
+		defer cancelProbes()
 
-		// TODO: need to implement a timeout mechanic
-		// TODO: need to support context cancellation for ctrl+c handling
-		// TODO: need to be able to signal "done" with an error (i.e. timeout or ctrl+c)
+		// readied is closed once this attempt is ready
+		// or can no longer become ready.
+		readied := make(chan struct{})
 		go func() {
+			defer close(readied)
+
+			isReady := true
 			if p.Ready != "" {
-				done <- <-WaitForText(ctx, r, p.Ready)
+				isReady = <-WaitForText(probectx, r, p.Ready)
 			} else {
-				done <- true
 				// TODO: this is a hack because we need to consume the reader
 				// otherwise the program blocks.
 				// WiatForText() consumes the reader in a goroutine which is hacky too.
-				io.Copy(ioutil.Discard, r)
+				go io.Copy(ioutil.Discard, r)
+			}
+
+			// polling stops once the process exits because probectx is cancelled
+			if probes := p.probes(); isReady && len(probes) > 0 {
+				isReady = Poll(probectx, p.interval(), probes...)
+			}
+
+			if !isReady {
+				return
+			}
+			if restarted {
+				fmt.Fprintln(p.Stderr, "ready again after restarting")
+			}
+			select {
+			case <-ready:
+			default:
+				close(ready)
 			}
 		}()
 
-		return execext.RunCommands(ctx, p.Cmds, &execext.RunCommandOptions{
+		err := execext.RunCommands(ctx, p.Cmds, &execext.RunCommandOptions{
 			Env:    os.Environ(),
 			Dir:    p.Cwd,
 			Stdout: w,
-			Stderr: p.Stderr,
+			Stderr: io.MultiWriter(p.Stderr, stderr),
+
+			// the process is stopped by the process manager in order,
+			// so it mustn't receive a ctrl+c from the terminal directly.
+			KillTimeout: p.StopTimeout,
+			Isolated:    true,
 		})
+
+		// closing the pipe and cancelling the probes means
+		// the readiness checks give up now the process exited.
+		w.Close()
+		cancelProbes()
+		<-readied
+
+		return err
+	}
+
+	onExit := func(e pm.Exit) {
+		if e.Restarting {
+			fmt.Fprintf(p.Stderr, "exited (%s), restarting in %s (restart %d of %d)\n", describeExit(e.Err), e.Backoff, e.Restarts+1, p.Restart.MaxRestarts)
+			return
+		}
+
+		select {
+		case <-ready:
+			if e.Restarts > 0 && processctx.Err() == nil {
+				fmt.Fprintf(p.Stderr, "exited (%s), not restarting after %d restart(s)\n", describeExit(e.Err), e.Restarts)
+			}
+		default:
+			// failing to become ready is reported by Execute,
+			// it shouldn't stop every other process too.
+			notReady <- e
+			stop()
+		}
+	}
+
+	processManager.Start(attempt,
+		pm.WithContext(processctx),
+		pm.WithRestart(p.Restart),
+		pm.OnExit(onExit),
+		pm.BeforeStop(p.stop),
+	)
+
+	t := p.ReadyTimeout
+	if t <= 0 {
+		t = timeout(ctx, p.Timeout)
+	}
+
+	var expired <-chan time.Time
+	if t > 0 {
+		timer := time.NewTimer(t)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	end := recorder.Span(p.IID, "waiting for ready", "ready")
+	defer end()
+
+	select {
+	case <-ready:
+		return nil
+	case e := <-notReady:
+		reason := "exited before it was ready"
+		if p.Ready != "" {
+			reason = fmt.Sprintf("exited without printing %q", p.Ready)
+		}
+		if e.Restarts > 0 {
+			reason = fmt.Sprintf("%s after %d restart(s)", reason, e.Restarts)
+		}
+
+		return &NotReadyError{ID: p.IID, Reason: reason, Err: e.Err, Output: output.Lines()}
+	case <-ctx.Done():
+		stop()
+		return ctx.Err()
+	case <-expired:
+		stop()
+		return &NotReadyError{ID: p.IID, Reason: "not ready", Err: &TimeoutError{ID: p.IID, Timeout: t}, Output: output.Lines()}
+	}
+}
+
+// stop runs the stop commands of the process.
+func (p *Process) stop() {
+	if len(p.StopCmds) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if p.StopTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.StopTimeout)
+		defer cancel()
+	}
+
+	err := execext.RunCommands(ctx, p.StopCmds, &execext.RunCommandOptions{
+		Env:    os.Environ(),
+		Dir:    p.Cwd,
+		Stdout: p.Stdout,
+		Stderr: p.Stderr,
 	})
+	if err != nil {
+		fmt.Fprintf(p.Stderr, "stop commands failed: %s\n", err)
+	}
+}
+
+func describeExit(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// probes returns the readiness probes of the process.
+func (p *Process) probes() []Probe {
+	probes := []Probe{}
+	if p.ReadyHTTP != "" {
+		probes = append(probes, HTTPProbe(p.ReadyHTTP))
+	}
+	if p.ReadyTCP != "" {
+		probes = append(probes, TCPProbe(p.ReadyTCP))
+	}
+	if p.ReadyCmd != "" {
+		probes = append(probes, CommandProbe(p.ReadyCmd, p.Cwd))
+	}
+	return probes
+}
 
-	<-done
+func (p *Process) interval() time.Duration {
+	if p.ReadyInterval > 0 {
+		return p.ReadyInterval
+	}
+	return DefaultReadyInterval
+}
 
-	return nil
+// Requires implements Constrained
+func (p *Process) Requires() []Resource {
+	return p.Resources
 }
 
 // ID implements Rule
@@ -80,11 +308,17 @@ func (p *Process) Outputs() []string {
 	return []string{}
 }
 
+// Excludes implements Rule
+func (p *Process) Excludes() []string {
+	return []string{}
+}
+
 func (t *Process) Getwd() string {
 	return t.Cwd
 }
 
 var _ Rule = &Process{}
+var _ Constrained = &Process{}
 
 // TODO: need to support context cancellation.
 func WaitForText(ctx context.Context, in io.Reader, search string) chan bool {
@@ -124,6 +358,12 @@ func SplitAt(substring string) func(data []byte, atEOF bool) (advance int, token
 			return 0, nil, io.EOF
 		}
 
-		return 1, nil, nil
+		// keep the end of the data in case it is the start of the
+		// substring and the rest hasn't been written yet.
+		if keep := len(substring) - 1; len(data) > keep {
+			return len(data) - keep, nil, nil
+		}
+
+		return 0, nil, nil
 	}
 }
@@ -2,8 +2,13 @@ package rules
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
+	"taskgraph/internal/pm"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -25,3 +30,140 @@ func TestWaitForTextNoMatch(t *testing.T) {
 
 	require.False(<-c)
 }
+
+func newProcess(t *testing.T, cmds ...string) (*Process, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ctx = context.WithValue(ctx, "pm.ProcessManager", pm.New(ctx))
+
+	return &Process{
+		IID:    "//pkg:server",
+		Cmds:   cmds,
+		Cwd:    t.TempDir(),
+		Stdout: ioutil.Discard,
+		Stderr: ioutil.Discard,
+	}, ctx
+}
+
+func TestProcessReady(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "echo starting", "echo listening", "sleep 10")
+	p.Ready = "listening"
+
+	require.NoError(p.Execute(ctx))
+}
+
+func TestProcessExitsBeforeReady(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "echo starting", "echo 'no space left on device' >&2", "exit 3")
+	p.Ready = "listening"
+
+	err := p.Execute(ctx)
+	require.ErrorContains(err, "exited without printing \"listening\": exit status 3, last output:\n")
+
+	var notReady *NotReadyError
+	require.ErrorAs(err, &notReady)
+	// stdout and stderr are read separately so the order can vary
+	require.ElementsMatch([]string{"starting", "no space left on device"}, notReady.Output)
+}
+
+func TestProcessExitsBeforeProbeSucceeds(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "true")
+	p.ReadyCmd = "false"
+
+	require.EqualError(p.Execute(ctx), "exited before it was ready (no output)")
+}
+
+func TestTail(t *testing.T) {
+	require := require.New(t)
+
+	tail := newTail(3)
+	stdout, stderr := tail.Stream(), tail.Stream()
+	stdout.Write([]byte("one\ntwo\nthr"))
+	stderr.Write([]byte("error\n"))
+	stdout.Write([]byte("ee\r\nfour"))
+
+	require.Equal([]string{"error", "three", "four"}, tail.Lines())
+}
+
+func TestProcessRestartsUntilReady(t *testing.T) {
+	require := require.New(t)
+
+	// crashes the first time it is started
+	p, ctx := newProcess(t, "test -f started || (touch started && exit 1)", "echo listening", "sleep 10")
+	p.Ready = "listening"
+	p.Restart = pm.RestartPolicy{Restart: pm.RestartOnFailure, Backoff: time.Millisecond, MaxRestarts: 1}
+
+	require.NoError(p.Execute(ctx))
+}
+
+func TestProcessRestartsAreLimited(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "exit 1")
+	p.Ready = "listening"
+	p.Restart = pm.RestartPolicy{Restart: pm.RestartOnFailure, Backoff: time.Millisecond, MaxRestarts: 2}
+
+	require.EqualError(p.Execute(ctx), "exited without printing \"listening\" after 2 restart(s): exit status 1 (no output)")
+}
+
+func TestProcessCancelledWhileWaitingForReady(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "sleep 10")
+	p.Ready = "listening"
+
+	taskctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	require.ErrorIs(p.Execute(taskctx), context.Canceled)
+	require.Less(time.Since(start), 5*time.Second)
+}
+
+func TestProcessReadyTimeoutTakesPrecedence(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "sleep 10")
+	p.Ready = "listening"
+	p.Timeout = time.Hour
+	p.ReadyTimeout = 100 * time.Millisecond
+
+	var timeout *TimeoutError
+	require.ErrorAs(p.Execute(ctx), &timeout)
+	require.Equal(p.ReadyTimeout, timeout.Timeout)
+}
+
+func TestProcessStopCommands(t *testing.T) {
+	require := require.New(t)
+
+	p, ctx := newProcess(t, "sleep 10")
+	p.StopCmds = []string{"touch stopped"}
+	p.StopTimeout = time.Second
+
+	require.NoError(p.Execute(ctx))
+
+	start := time.Now()
+	ctx.Value("pm.ProcessManager").(pm.ProcessManager).Shutdown()
+	require.Less(time.Since(start), 5*time.Second)
+	require.FileExists(filepath.Join(p.Cwd, "stopped"))
+}
+
+func TestWaitForTextSplitAcrossWrites(t *testing.T) {
+	require := require.New(t)
+
+	r, w := io.Pipe()
+	go func() {
+		for _, s := range []string{"srv", " up\n"} {
+			w.Write([]byte(s))
+		}
+		w.Close()
+	}()
+
+	require.True(<-WaitForText(context.Background(), r, "srv up"))
+}
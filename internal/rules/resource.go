@@ -0,0 +1,41 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resource is a named pool shared by rules that can't
+// all run at the same time, such as a database.
+type Resource struct {
+	Name string
+	// Capacity is the number of rules that can
+	// use the resource at the same time.
+	Capacity int
+}
+
+// ParseResource parses "name" or "name:capacity".
+// The capacity defaults to 1.
+func ParseResource(s string) (Resource, error) {
+	name, capacity, found := strings.Cut(s, ":")
+	r := Resource{Name: name, Capacity: 1}
+
+	if name == "" {
+		return r, fmt.Errorf("invalid resource %q, expected \"name\" or \"name:capacity\"", s)
+	}
+
+	if found {
+		n, err := strconv.Atoi(capacity)
+		if err != nil || n < 1 {
+			return r, fmt.Errorf("invalid resource %q, the capacity must be a positive number", s)
+		}
+		r.Capacity = n
+	}
+
+	return r, nil
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s:%d", r.Name, r.Capacity)
+}
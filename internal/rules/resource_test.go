@@ -0,0 +1,25 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResource(t *testing.T) {
+	require := require.New(t)
+
+	r, err := ParseResource("db")
+	require.NoError(err)
+	require.Equal(Resource{Name: "db", Capacity: 1}, r)
+
+	r, err = ParseResource("nuget:2")
+	require.NoError(err)
+	require.Equal(Resource{Name: "nuget", Capacity: 2}, r)
+
+	_, err = ParseResource("nuget:0")
+	require.Error(err)
+
+	_, err = ParseResource(":1")
+	require.Error(err)
+}
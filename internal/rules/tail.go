@@ -0,0 +1,77 @@
+package rules
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// tail keeps the last n lines written to any of its streams.
+type tail struct {
+	n int
+
+	mu      sync.Mutex
+	lines   []string
+	streams []*stream
+}
+
+// stream buffers a partial line so that lines written to
+// different streams at the same time aren't mixed up.
+type stream struct {
+	t    *tail
+	line []byte
+}
+
+func newTail(n int) *tail {
+	return &tail{n: n}
+}
+
+// Stream returns a writer for one source of output, such as stdout.
+func (t *tail) Stream() io.Writer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &stream{t: t}
+	t.streams = append(t.streams, s)
+	return s
+}
+
+func (s *stream) Write(b []byte) (int, error) {
+	t := s.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range b {
+		if c != '\n' {
+			s.line = append(s.line, c)
+			continue
+		}
+
+		t.lines = append(t.lines, strings.TrimRight(string(s.line), "\r"))
+		if len(t.lines) > t.n {
+			t.lines = t.lines[1:]
+		}
+		s.line = s.line[:0]
+	}
+
+	return len(b), nil
+}
+
+// Lines returns the last lines, including any
+// lines that haven't been terminated yet.
+func (t *tail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string{}, t.lines...)
+	for _, s := range t.streams {
+		if len(s.line) > 0 {
+			lines = append(lines, strings.TrimRight(string(s.line), "\r"))
+		}
+	}
+	if len(lines) > t.n {
+		lines = lines[len(lines)-t.n:]
+	}
+
+	return lines
+}
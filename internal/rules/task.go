@@ -2,9 +2,12 @@ package rules
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"taskgraph/internal/execext"
+	"taskgraph/internal/output"
+	"time"
 )
 
 type Task struct {
@@ -14,19 +17,79 @@ type Task struct {
 	Deps []string
 	Cmds []string
 
+	// Exclude are patterns for files that shouldn't be
+	// considered part of the srcs.
+	Exclude []string
+
+	// EnvInputs are the names of environment variables
+	// that affect the outputs of the task.
+	EnvInputs []string
+
+	// Timeout cancels the task if it takes longer, 0 means no timeout.
+	Timeout time.Duration
+
+	// Retries is the number of times the commands are
+	// run again after failing. The backoff before each
+	// retry starts at RetryBackoff and doubles every time.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Resources are held while the task executes.
+	Resources []Resource
+
 	Cwd    string
 	Stdout io.Writer
 	Stderr io.Writer
+	// Output creates the writers for each attempt when retrying.
+	Output output.OutputFactory
+
+	attempts int
 }
 
 // Execute implements Rule
 func (t *Task) Execute(ctx context.Context) error {
-	return execext.RunCommands(ctx, t.Cmds, &execext.RunCommandOptions{
-		Env:    os.Environ(),
-		Dir:    t.Cwd,
-		Stdout: t.Stdout,
-		Stderr: t.Stderr,
-	})
+	backoff := t.RetryBackoff
+
+	for t.attempts = 1; ; t.attempts++ {
+		stdout, stderr := t.Stdout, t.Stderr
+		if t.Retries > 0 && t.Output != nil {
+			prefix := fmt.Sprintf("%s #%d", t.IID, t.attempts)
+			stdout, stderr = t.Output.Stdout(prefix), t.Output.Stderr(prefix)
+		}
+
+		err := withTimeout(ctx, t.IID, t.Timeout, func(ctx context.Context) error {
+			return execext.RunCommands(ctx, t.Cmds, &execext.RunCommandOptions{
+				Env:    os.Environ(),
+				Dir:    t.Cwd,
+				Stdout: stdout,
+				Stderr: stderr,
+			})
+		})
+
+		// there's no point retrying once the run has been cancelled
+		if err == nil || t.attempts > t.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		fmt.Fprintf(stderr, "attempt %d of %d failed: %s, retrying in %s\n", t.attempts, t.Retries+1, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Requires implements Constrained
+func (t *Task) Requires() []Resource {
+	return t.Resources
+}
+
+// Attempts implements Retrier
+func (t *Task) Attempts() int {
+	return t.attempts
 }
 
 // Dependencies implements Rule
@@ -44,6 +107,28 @@ func (t *Task) Outputs() []string {
 	return t.Outs
 }
 
+// Excludes implements Rule
+func (t *Task) Excludes() []string {
+	return t.Exclude
+}
+
+// Definition implements Definer
+func (t *Task) Definition() []string {
+	d := []string{}
+	for _, cmd := range t.Cmds {
+		d = append(d, "cmd:"+cmd)
+	}
+	for _, out := range t.Outs {
+		d = append(d, "out:"+out)
+	}
+	return d
+}
+
+// Environment implements Definer
+func (t *Task) Environment() []string {
+	return t.EnvInputs
+}
+
 func (t *Task) ID() string {
 	return t.IID
 }
@@ -53,3 +138,6 @@ func (t *Task) Getwd() string {
 }
 
 var _ Rule = &Task{}
+var _ Definer = &Task{}
+var _ Retrier = &Task{}
+var _ Constrained = &Task{}
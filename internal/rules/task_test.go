@@ -0,0 +1,65 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskRetriesUntilSuccess(t *testing.T) {
+	require := require.New(t)
+
+	// fails until it has been run twice
+	task := &Task{
+		IID:     "//pkg:flaky",
+		Cmds:    []string{"echo . >> attempts", "test $(wc -l < attempts) -ge 2"},
+		Retries: 3,
+		Cwd:     t.TempDir(),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+
+	require.NoError(task.Execute(context.Background()))
+	require.Equal(2, task.Attempts())
+}
+
+func TestTaskRetriesAreLimited(t *testing.T) {
+	require := require.New(t)
+
+	task := &Task{
+		IID:          "//pkg:broken",
+		Cmds:         []string{"exit 1"},
+		Retries:      2,
+		RetryBackoff: 10 * time.Millisecond,
+		Cwd:          t.TempDir(),
+		Stdout:       ioutil.Discard,
+		Stderr:       ioutil.Discard,
+	}
+
+	start := time.Now()
+	require.Error(task.Execute(context.Background()))
+	require.Equal(3, task.Attempts())
+	// the backoff doubles, 10ms then 20ms
+	require.GreaterOrEqual(time.Since(start), 30*time.Millisecond)
+}
+
+func TestChecksumReportsFlakyTasks(t *testing.T) {
+	require := require.New(t)
+
+	task := &Task{
+		IID:     "//pkg:flaky",
+		Cmds:    []string{"echo . >> attempts", "test $(wc -l < attempts) -ge 2"},
+		Retries: 1,
+		Cwd:     t.TempDir(),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+	c := &Checksum{Inner: task, WorkspaceDir: task.Cwd, Stdout: ioutil.Discard}
+
+	require.NoError(c.Execute(context.Background()))
+	require.Equal(StatusFlaky, c.Status())
+	require.Equal(2, c.Attempts())
+}
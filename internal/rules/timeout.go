@@ -0,0 +1,46 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is returned when a rule takes longer than its timeout.
+type TimeoutError struct {
+	ID      string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// timeout returns the timeout of a rule, falling back
+// to the default given by the global --timeout flag.
+func timeout(ctx context.Context, t time.Duration) time.Duration {
+	if t > 0 {
+		return t
+	}
+	d, _ := ctx.Value("rules.DefaultTimeout").(time.Duration)
+	return d
+}
+
+// withTimeout runs fn with a context that is cancelled once
+// the timeout has elapsed. A timeout of 0 means no timeout.
+func withTimeout(ctx context.Context, id string, t time.Duration, fn func(ctx context.Context) error) error {
+	t = timeout(ctx, t)
+	if t <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+
+	err := fn(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{ID: id, Timeout: t}
+	}
+
+	return err
+}
@@ -0,0 +1,88 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"taskgraph/internal/pm"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskTimeout(t *testing.T) {
+	require := require.New(t)
+
+	task := &Task{
+		IID:     "//pkg:hang",
+		Cmds:    []string{"sleep 10"},
+		Timeout: 100 * time.Millisecond,
+		Cwd:     t.TempDir(),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+
+	start := time.Now()
+	err := task.Execute(context.Background())
+	require.Less(time.Since(start), 5*time.Second)
+	require.Equal(&TimeoutError{ID: "//pkg:hang", Timeout: 100 * time.Millisecond}, err)
+	require.EqualError(err, "timed out after 100ms")
+}
+
+func TestTaskDefaultTimeout(t *testing.T) {
+	require := require.New(t)
+
+	task := &Task{
+		IID:    "//pkg:hang",
+		Cmds:   []string{"sleep 10"},
+		Cwd:    t.TempDir(),
+		Stdout: ioutil.Discard,
+		Stderr: ioutil.Discard,
+	}
+
+	ctx := context.WithValue(context.Background(), "rules.DefaultTimeout", 100*time.Millisecond)
+	require.IsType(&TimeoutError{}, task.Execute(ctx))
+}
+
+func TestTaskWithinTimeout(t *testing.T) {
+	require := require.New(t)
+
+	task := &Task{
+		IID:     "//pkg:build",
+		Cmds:    []string{"true"},
+		Timeout: 5 * time.Second,
+		Cwd:     t.TempDir(),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+
+	require.NoError(task.Execute(context.Background()))
+}
+
+func TestProcessReadyTimeout(t *testing.T) {
+	require := require.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processManager := pm.New(ctx)
+	ctx = context.WithValue(ctx, "pm.ProcessManager", processManager)
+
+	p := &Process{
+		IID:     "//pkg:server",
+		Cmds:    []string{"sleep 10"},
+		Ready:   "listening",
+		Timeout: 100 * time.Millisecond,
+		Cwd:     t.TempDir(),
+		Stdout:  ioutil.Discard,
+		Stderr:  ioutil.Discard,
+	}
+
+	start := time.Now()
+	var timeout *TimeoutError
+	require.ErrorAs(p.Execute(ctx), &timeout)
+
+	// the process is stopped rather than left running
+	require.NoError(processManager.Wait())
+	require.Less(time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,121 @@
+package taskengine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"taskgraph/internal/execgraph"
+	"taskgraph/internal/rules"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	StatusFailed    rules.Status = "failed"
+	StatusSkipped   rules.Status = "skipped"
+	StatusCancelled rules.Status = "cancelled"
+	StatusTimedOut  rules.Status = "timed out"
+)
+
+// Result is the outcome of a task after Execute.
+type Result struct {
+	ID       string
+	Status   rules.Status
+	Start    time.Time
+	Duration time.Duration
+	// Attempts is the number of times the task was attempted,
+	// it's only more than 1 when a task was retried.
+	Attempts int
+	Err      error
+}
+
+// Results implements Engine
+func (e *engine) Results() []Result {
+	e.resultsrw.Lock()
+	defer e.resultsrw.Unlock()
+
+	results := lo.FilterMap(lo.Values(e.results), func(r *Result, i int) (Result, bool) {
+		return *r, !lo.Contains(e.hidden, r.ID)
+	})
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+
+	return results
+}
+
+// Summary implements Engine
+func (e *engine) Summary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+
+	fmt.Fprintln(tw, "TASK\tSTATUS\tDURATION")
+	for _, r := range e.Results() {
+		duration := "-"
+		if r.Status != StatusSkipped {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+		status := string(r.Status)
+		if r.Attempts > 1 {
+			status = fmt.Sprintf("%s (%d attempts)", status, r.Attempts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.ID, status, duration)
+	}
+
+	return tw.Flush()
+}
+
+func (e *engine) record(task rules.Rule, start time.Time, err error) {
+	r := &Result{
+		ID:       task.ID(),
+		Status:   rules.StatusExecuted,
+		Start:    start,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+
+	if retrier, ok := task.(rules.Retrier); ok {
+		r.Attempts = retrier.Attempts()
+	}
+
+	var timeout *rules.TimeoutError
+	if errors.As(err, &timeout) {
+		r.Status = StatusTimedOut
+	} else if err != nil {
+		r.Status = StatusFailed
+	} else if reporter, ok := task.(rules.Reporter); ok {
+		r.Status = reporter.Status()
+	}
+
+	e.resultsrw.Lock()
+	defer e.resultsrw.Unlock()
+	e.results[r.ID] = r
+}
+
+// skip records a task that wasn't executed, or that was
+// cancelled because of a failure elsewhere in the graph.
+func (e *engine) skip(id string, err *execgraph.SkippedError) {
+	e.resultsrw.Lock()
+	defer e.resultsrw.Unlock()
+
+	if r, ok := e.results[id]; ok {
+		r.Status = StatusCancelled
+		r.Err = err
+		return
+	}
+
+	e.results[id] = &Result{
+		ID:     id,
+		Status: StatusSkipped,
+		Err:    err,
+	}
+}
+
+// failed returns the ids of the tasks that failed.
+func (e *engine) failed() []string {
+	return lo.FilterMap(e.Results(), func(r Result, i int) (string, bool) {
+		return r.ID, r.Status == StatusFailed || r.Status == StatusTimedOut
+	})
+}
@@ -8,7 +8,10 @@ import (
 	"taskgraph/internal/execgraph"
 	"taskgraph/internal/rules"
 	"taskgraph/internal/taskgraph"
+	"time"
 
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
 	"github.com/xlab/treeprint"
 	"golang.org/x/sync/errgroup"
 )
@@ -16,13 +19,59 @@ import (
 type Engine interface {
 	Execute(ctx context.Context, graph taskgraph.TaskGraph, task string) error
 	Tree(w io.Writer, graph taskgraph.TaskGraph, taskID string) error
+	Results() []Result
+	Summary(w io.Writer) error
 }
 
-func New() Engine {
-	return &engine{}
+type Option func(e *engine)
+
+// WithJobs limits the number of tasks that execute at the same time.
+// Processes stop counting towards the limit once they are ready.
+func WithJobs(n int) Option {
+	return func(e *engine) {
+		e.jobs = n
+	}
+}
+
+// WithKeepGoing executes every task that doesn't depend
+// on a failed task instead of stopping at the first failure.
+func WithKeepGoing(enabled bool) Option {
+	return func(e *engine) {
+		e.keepGoing = enabled
+	}
+}
+
+// WithTracer records the timeline of every task executed.
+func WithTracer(t execgraph.Tracer) Option {
+	return func(e *engine) {
+		e.tracer = t
+	}
+}
+
+// WithHidden leaves the tasks out of the results and the summary,
+// for tasks that only group other tasks and weren't declared by the user.
+func WithHidden(ids ...string) Option {
+	return func(e *engine) {
+		e.hidden = append(e.hidden, ids...)
+	}
+}
+
+func New(opts ...Option) Engine {
+	e := &engine{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 type engine struct {
+	jobs      int
+	keepGoing bool
+	tracer    execgraph.Tracer
+	hidden    []string
+
+	resultsrw sync.Mutex
+	results   map[string]*Result
 }
 
 func (e *engine) Tree(w io.Writer, graph taskgraph.TaskGraph, taskID string) error {
@@ -50,28 +99,84 @@ func (e *engine) tree(graph taskgraph.TaskGraph, tree treeprint.Tree, t rules.Ru
 
 // Execute implements Engine
 func (e *engine) Execute(ctx context.Context, graph taskgraph.TaskGraph, taskID string) error {
-	eg := execgraph.New()
+	opts := []execgraph.Option{
+		execgraph.WithConcurrency(e.jobs),
+		execgraph.WithKeepGoing(e.keepGoing),
+	}
+	if e.tracer != nil {
+		opts = append(opts, execgraph.WithTracer(e.tracer))
+	}
+
+	capacities, err := resources(graph.Tasks())
+	if err != nil {
+		return err
+	}
+	opts = append(opts, execgraph.WithResources(capacities))
+
+	eg := execgraph.New(opts...)
+
+	e.results = map[string]*Result{}
 
 	for _, v := range graph.Tasks() {
-		eg.Add(v.ID(), v.Execute)
+		task := v
+
+		nodeOpts := []execgraph.NodeOption{}
+		if c, ok := task.(rules.Constrained); ok {
+			nodeOpts = append(nodeOpts, execgraph.Requires(lo.Map(c.Requires(), func(r rules.Resource, i int) string {
+				return r.Name
+			})...))
+		}
+
+		eg.Add(task.ID(), func(ctx context.Context) error {
+			start := time.Now()
+			err := task.Execute(ctx)
+			e.record(task, start, err)
+			return err
+		}, nodeOpts...)
 	}
 
 	for _, e := range graph.Dependencies() {
 		eg.AddDependency(e[0], e[1])
 	}
 
-	return eg.Execute(ctx, taskID)
+	err = eg.Execute(ctx, taskID)
+
+	for id, result := range eg.Results() {
+		if skipped, ok := result.(*execgraph.SkippedError); ok {
+			e.skip(id, skipped)
+			logrus.Warn(skipped)
+		}
+	}
+
+	if failed := e.failed(); len(failed) > 0 {
+		return fmt.Errorf("%d task(s) failed: %w", len(failed), err)
+	}
+
+	return err
+}
+
+// resources returns the capacity of every resource used by the
+// tasks. Tasks sharing a resource must agree on its capacity.
+func resources(tasks []rules.Rule) (map[string]int, error) {
+	capacities := map[string]int{}
+	declaredBy := map[string]string{}
+
+	for _, t := range tasks {
+		c, ok := t.(rules.Constrained)
+		if !ok {
+			continue
+		}
+
+		for _, r := range c.Requires() {
+			if n, ok := capacities[r.Name]; ok && n != r.Capacity {
+				return nil, fmt.Errorf("resource %q has a capacity of %d in %s but %d in %s", r.Name, n, declaredBy[r.Name], r.Capacity, t.ID())
+			}
+			capacities[r.Name] = r.Capacity
+			declaredBy[r.Name] = t.ID()
+		}
+	}
 
-	// TODO: remove and see if we can refactor the taskgraph, execgraph and taskengine to feel less messy
-	//
-	// walk := graphwalk{}
-	// limit := semaphore.NewWeighted(int64(runtime.NumCPU()))
-	// return walk.concurrentWalk(ctx, graph, 0, graph.FindTask(taskID), func(ctx context.Context, depth int, t rules.Rule) error {
-	// 	limit.Acquire(ctx, 1)
-	// 	defer limit.Release(1)
-	// 	logrus.Debugf("executing task depth=%d id=%s", depth, t.ID())
-	// 	return t.Execute(ctx)
-	// })
+	return capacities, nil
 }
 
 type graphwalk struct {
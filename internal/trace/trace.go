@@ -0,0 +1,99 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recorder collects spans and writes them in the Chrome Trace Event
+// format which can be opened in about://tracing or Perfetto.
+//
+// A nil *Recorder is valid and doesn't record anything.
+type Recorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []event
+	tracks map[string]int
+}
+
+type event struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Ts       int64             `json:"ts"`
+	Dur      int64             `json:"dur,omitempty"`
+	Pid      int               `json:"pid"`
+	Tid      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+func New() *Recorder {
+	return &Recorder{
+		start:  time.Now(),
+		events: []event{},
+		tracks: map[string]int{},
+	}
+}
+
+// Span starts a span on the given track and returns
+// a function that ends it. Each track is shown as a
+// separate row in the trace viewer.
+func (r *Recorder) Span(track string, name string, category string) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+
+	return func() {
+		end := time.Now()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.events = append(r.events, event{
+			Name:     name,
+			Category: category,
+			Phase:    "X",
+			Ts:       start.Sub(r.start).Microseconds(),
+			Dur:      end.Sub(start).Microseconds(),
+			Pid:      1,
+			Tid:      r.track(track),
+		})
+	}
+}
+
+// WriteJSON writes the recorded spans to w.
+func (r *Recorder) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := append([]event{}, r.events...)
+	for name, tid := range r.tracks {
+		events = append(events, event{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   1,
+			Tid:   tid,
+			Args:  map[string]string{"name": name},
+		})
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
+
+// track returns the thread id used for the track.
+// Must be called with the lock held.
+func (r *Recorder) track(name string) int {
+	if tid, ok := r.tracks[name]; ok {
+		return tid
+	}
+	tid := len(r.tracks) + 1
+	r.tracks[name] = tid
+	return tid
+}
@@ -0,0 +1,34 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+	require := require.New(t)
+
+	r := New()
+	r.Span("//pkg:build", "//pkg:build", "run")()
+	r.Span("//pkg:test", "//pkg:test", "run")()
+
+	buf := &bytes.Buffer{}
+	require.NoError(r.WriteJSON(buf))
+
+	out := struct {
+		TraceEvents []event `json:"traceEvents"`
+	}{}
+	require.NoError(json.Unmarshal(buf.Bytes(), &out))
+	require.Len(out.TraceEvents, 4)
+	require.Equal("X", out.TraceEvents[0].Phase)
+	require.Equal(1, out.TraceEvents[0].Tid)
+	require.Equal(2, out.TraceEvents[1].Tid)
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.Span("track", "name", "category")()
+}
@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 	"strings"
 	"taskgraph/internal/output"
+	"taskgraph/internal/pm"
 	"taskgraph/internal/rules"
+	"time"
 
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
@@ -30,32 +32,71 @@ func Exec(ctx context.Context, packageName string, file string) ([]rules.Rule, e
 		deps := &starlark.List{}
 		outs := &starlark.List{}
 		cmds := &starlark.List{}
+		exclude := &starlark.List{}
+		envInputs := &starlark.List{}
+		timeout := ""
+		retries := 0
+		retryBackoff := ""
+		resources := &starlark.List{}
 		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
 			"name", &name,
 			"srcs?", &srcs,
 			"outs?", &outs,
 			"deps?", &deps,
-			"cmds", &cmds); err != nil {
+			"cmds", &cmds,
+			"exclude?", &exclude,
+			"env_inputs?", &envInputs,
+			"timeout?", &timeout,
+			"retries?", &retries,
+			"retry_backoff?", &retryBackoff,
+			"resources?", &resources); err != nil {
 			return nil, err
 		}
 
 		fqname := fmt.Sprintf("%s:%s", packageName, name)
 
+		t, err := toduration(fn.Name(), "timeout", timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if retries < 0 {
+			return nil, fmt.Errorf("%s: retries must not be negative", fn.Name())
+		}
+
+		backoff, err := toduration(fn.Name(), "retry_backoff", retryBackoff)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := toresources(fn.Name(), resources)
+		if err != nil {
+			return nil, err
+		}
+
 		r = append(r, &rules.Task{
-			IID:  fqname,
-			Srcs: tostrarr(srcs),
-			Outs: tostrarr(outs),
-			Cmds: tostrarr(cmds),
+			IID:     fqname,
+			Srcs:    tostrarr(srcs),
+			Outs:    tostrarr(outs),
+			Cmds:    tostrarr(cmds),
+			Exclude: tostrarr(exclude),
 			Deps: lo.Map(tostrarr(deps), func(d string, i int) string {
 				if strings.HasPrefix(d, ":") {
 					return fmt.Sprintf("%s%s", packageName, d)
 				}
 				return d
 			}),
+			EnvInputs: tostrarr(envInputs),
+			Timeout:   t,
+
+			Retries:      retries,
+			RetryBackoff: backoff,
+			Resources:    res,
 
 			Cwd:    cwd,
 			Stdout: out.Stdout(fqname),
 			Stderr: out.Stderr(fqname),
+			Output: out,
 		})
 
 		return starlark.None, nil
@@ -66,16 +107,79 @@ func Exec(ctx context.Context, packageName string, file string) ([]rules.Rule, e
 		deps := &starlark.List{}
 		cmds := &starlark.List{}
 		ready := ""
+		readyHTTP := ""
+		readyTCP := ""
+		readyCmd := ""
+		readyInterval := ""
+		readyTimeout := ""
+		restart := string(pm.RestartNo)
+		restartBackoff := "1s"
+		maxRestarts := 5
+		stopCmds := &starlark.List{}
+		stopTimeout := ""
+		timeout := ""
+		resources := &starlark.List{}
 		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
 			"name", &name,
 			"deps?", &deps,
 			"cmds", &cmds,
-			"ready", &ready); err != nil {
+			"ready?", &ready,
+			"ready_http?", &readyHTTP,
+			"ready_tcp?", &readyTCP,
+			"ready_cmd?", &readyCmd,
+			"ready_interval?", &readyInterval,
+			"ready_timeout?", &readyTimeout,
+			"restart?", &restart,
+			"restart_backoff?", &restartBackoff,
+			"max_restarts?", &maxRestarts,
+			"stop_cmds?", &stopCmds,
+			"stop_timeout?", &stopTimeout,
+			"timeout?", &timeout,
+			"resources?", &resources); err != nil {
 			return nil, err
 		}
 
 		fqname := fmt.Sprintf("%s:%s", packageName, name)
 
+		t, err := toduration(fn.Name(), "timeout", timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := toresources(fn.Name(), resources)
+		if err != nil {
+			return nil, err
+		}
+
+		interval, err := toduration(fn.Name(), "ready_interval", readyInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		readyDeadline, err := toduration(fn.Name(), "ready_timeout", readyTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := pm.ParseRestart(restart)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+
+		backoff, err := toduration(fn.Name(), "restart_backoff", restartBackoff)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxRestarts < 0 {
+			return nil, fmt.Errorf("%s: max_restarts must not be negative", fn.Name())
+		}
+
+		stopDeadline, err := toduration(fn.Name(), "stop_timeout", stopTimeout)
+		if err != nil {
+			return nil, err
+		}
+
 		r = append(r, &rules.Process{
 			IID:  fqname,
 			Cmds: tostrarr(cmds),
@@ -85,7 +189,21 @@ func Exec(ctx context.Context, packageName string, file string) ([]rules.Rule, e
 				}
 				return d
 			}),
-			Ready: ready,
+			Ready:         ready,
+			ReadyHTTP:     readyHTTP,
+			ReadyTCP:      readyTCP,
+			ReadyCmd:      readyCmd,
+			ReadyInterval: interval,
+			ReadyTimeout:  readyDeadline,
+			Timeout:       t,
+			Resources:     res,
+			Restart: pm.RestartPolicy{
+				Restart:     policy,
+				Backoff:     backoff,
+				MaxRestarts: maxRestarts,
+			},
+			StopCmds:    tostrarr(stopCmds),
+			StopTimeout: stopDeadline,
 
 			Cwd:    cwd,
 			Stdout: out.Stdout(fqname),
@@ -98,18 +216,21 @@ func Exec(ctx context.Context, packageName string, file string) ([]rules.Rule, e
 	filegroup := starlark.NewBuiltin("filegroup", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 		name := ""
 		srcs := &starlark.List{}
+		exclude := &starlark.List{}
 		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
 			"name", &name,
-			"srcs", &srcs); err != nil {
+			"srcs", &srcs,
+			"exclude?", &exclude); err != nil {
 			return nil, err
 		}
 
 		fqname := fmt.Sprintf("%s:%s", packageName, name)
 
 		r = append(r, &rules.Filegroup{
-			IID:  fqname,
-			Srcs: tostrarr(srcs),
-			Cwd:  cwd,
+			IID:     fqname,
+			Srcs:    tostrarr(srcs),
+			Exclude: tostrarr(exclude),
+			Cwd:     cwd,
 		})
 
 		return starlark.None, nil
@@ -126,6 +247,34 @@ func Exec(ctx context.Context, packageName string, file string) ([]rules.Rule, e
 	return r, nil
 }
 
+// toduration parses a duration such as "90s" or "5m",
+// an empty string is a duration of 0.
+func toduration(fn string, attr string, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid %s %q, expected a duration like \"90s\" or \"5m\"", fn, attr, s)
+	}
+
+	return d, nil
+}
+
+// toresources parses a list such as ["db", "nuget:1"].
+func toresources(fn string, l *starlark.List) ([]rules.Resource, error) {
+	resources := []rules.Resource{}
+	for _, s := range tostrarr(l) {
+		r, err := rules.ParseResource(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
 func tostrarr(l *starlark.List) []string {
 	out := make([]string, l.Len())
 	i := 0
@@ -0,0 +1,54 @@
+package starbuild
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+type WorkspaceConfig struct {
+	RemoteCache *RemoteCacheConfig
+}
+
+type RemoteCacheConfig struct {
+	URL      string
+	ReadOnly bool
+}
+
+func ExecWorkspace(ctx context.Context, file string) (*WorkspaceConfig, error) {
+	thread := &starlark.Thread{
+		Name: file,
+	}
+
+	config := &WorkspaceConfig{}
+
+	remoteCache := starlark.NewBuiltin("remote_cache", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		url := ""
+		readOnly := false
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+			"url", &url,
+			"read_only?", &readOnly); err != nil {
+			return nil, err
+		}
+
+		if config.RemoteCache != nil {
+			return nil, fmt.Errorf("%s: can only be declared once", fn.Name())
+		}
+
+		config.RemoteCache = &RemoteCacheConfig{
+			URL:      url,
+			ReadOnly: readOnly,
+		}
+
+		return starlark.None, nil
+	})
+
+	if _, err := starlark.ExecFile(thread, file, nil, starlark.StringDict{
+		"remote_cache": remoteCache,
+	}); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
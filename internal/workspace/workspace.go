@@ -34,6 +34,10 @@ func Load(ctx context.Context, workspace string) ([]rules.Rule, error) {
 	return r, nil
 }
 
+func LoadConfig(ctx context.Context, workspace string) (*starbuild.WorkspaceConfig, error) {
+	return starbuild.ExecWorkspace(ctx, workspace)
+}
+
 func packageName(workspace string, buildfile string) string {
 	x, err := filepath.Rel(filepath.Dir(workspace), filepath.Dir(buildfile))
 	if err != nil {
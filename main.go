@@ -3,19 +3,30 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"taskgraph/internal"
+	"taskgraph/internal/cache"
+	"taskgraph/internal/filehash"
+	"taskgraph/internal/history"
 	"taskgraph/internal/output"
 	"taskgraph/internal/pm"
 	"taskgraph/internal/rules"
 	"taskgraph/internal/taskengine"
 	"taskgraph/internal/taskgraph"
+	"taskgraph/internal/trace"
 	"taskgraph/internal/workspace"
+	"text/tabwriter"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -26,11 +37,39 @@ var (
 	app              = kingpin.New(internal.AppName, "todo help text")
 	verbose          = app.Flag("verbose", "enable verbose logging").Bool()
 	workspaceDirFlag = app.Flag("workspace", "the path to the workspace directory").Default(cwd).String()
+	readOnlyFlag     = app.Flag("remote-cache-read-only", "don't upload task outputs to the remote cache").Bool()
 
-	runcmd       = app.Command("run", "run a task from a build file")
-	runcmdTarget = runcmd.Arg("target", "a task name from a build file").Required().String()
+	runcmd          = app.Command("run", "run a task from a build file")
+	runcmdTarget    = runcmd.Arg("target", "a task name from a build file").Required().String()
+	runcmdJobs      = runcmd.Flag("jobs", "the maximum number of tasks to execute at the same time").Short('j').Default(strconv.Itoa(runtime.NumCPU())).Int()
+	runcmdKeepGoing = runcmd.Flag("keep-going", "execute every task that doesn't depend on a failed task").Short('k').Bool()
+	runcmdTimeout   = runcmd.Flag("timeout", "cancel any task that doesn't declare its own timeout after this long, e.g. 30m").Duration()
+	runcmdTrace     = runcmd.Flag("trace", "write a timeline of the run to a file in the Chrome Trace Event format").String()
+	runcmdKeepAlive = runcmd.Flag("keep-alive", "keep processes running after the target has executed, the default when the target is a process").Bool()
+	runcmdStop      = runcmd.Flag("stop-processes", "stop processes once the target has executed, the default when the target is a task").Bool()
 
 	listcmd = app.Command("list", "list all available tasks")
+
+	cleancmd       = app.Command("clean", "delete the outputs and checksums of a task and its dependencies")
+	cleancmdTarget = cleancmd.Arg("target", "a task name from a build file").Required().String()
+	cleancmdDryRun = cleancmd.Flag("dry-run", "list the files that would be deleted without deleting them").Bool()
+
+	whycmd       = app.Command("why", "explain why a task and its dependencies will run")
+	whycmdTarget = whycmd.Arg("target", "a task name from a build file").Required().String()
+
+	cachecmd             = app.Command("cache", "manage cached task outputs")
+	cacheexportcmd       = cachecmd.Command("export", "export the cached outputs of a target and its dependencies to a tarball")
+	cacheexportcmdTarget = cacheexportcmd.Arg("target", "a task name from a build file").Required().String()
+	cacheexportcmdFile   = cacheexportcmd.Arg("file", "the tarball to write").Required().String()
+	cacheimportcmd       = cachecmd.Command("import", "import cached outputs from a tarball")
+	cacheimportcmdFile   = cacheimportcmd.Arg("file", "the tarball to read").Required().String()
+
+	criticalpathcmd       = app.Command("critical-path", "show the longest chain of dependent tasks using durations from previous runs")
+	criticalpathcmdTarget = criticalpathcmd.Arg("target", "a task name from a build file").Required().String()
+
+	historycmd      = app.Command("history", "list recent runs or show the details of one")
+	historycmdRun   = historycmd.Arg("run", "the id of a run to show").Int()
+	historycmdLimit = historycmd.Flag("limit", "the maximum number of runs to list").Short('n').Default("20").Int()
 )
 
 func main() {
@@ -51,9 +90,28 @@ func main() {
 	var err error
 	switch cmd {
 	case runcmd.FullCommand():
-		err = run(ctx, *runcmdTarget, *workspaceDirFlag)
+		err = run(ctx, *runcmdTarget, runOptions{
+			jobs:      *runcmdJobs,
+			keepGoing: *runcmdKeepGoing,
+			timeout:   *runcmdTimeout,
+			trace:     *runcmdTrace,
+			keepAlive: *runcmdKeepAlive,
+			stop:      *runcmdStop,
+		}, *workspaceDirFlag)
+	case criticalpathcmd.FullCommand():
+		err = criticalPath(ctx, *criticalpathcmdTarget, *workspaceDirFlag)
+	case historycmd.FullCommand():
+		err = showHistory(*historycmdRun, *historycmdLimit, *workspaceDirFlag)
 	case listcmd.FullCommand():
 		err = list(ctx, *workspaceDirFlag)
+	case cleancmd.FullCommand():
+		err = clean(ctx, *cleancmdTarget, *cleancmdDryRun, *workspaceDirFlag)
+	case whycmd.FullCommand():
+		err = why(ctx, *whycmdTarget, *workspaceDirFlag)
+	case cacheexportcmd.FullCommand():
+		err = cacheExport(ctx, *cacheexportcmdTarget, *cacheexportcmdFile, *workspaceDirFlag)
+	case cacheimportcmd.FullCommand():
+		err = cacheImport(ctx, *cacheimportcmdFile, *workspaceDirFlag)
 	default:
 		logrus.Fatal(app.Help)
 	}
@@ -62,9 +120,26 @@ func main() {
 	}
 }
 
-func run(ctx context.Context, target string, workspaceDir string) error {
+type runOptions struct {
+	jobs      int
+	keepGoing bool
+	timeout   time.Duration
+	trace     string
+	keepAlive bool
+	stop      bool
+}
+
+func run(ctx context.Context, target string, opts runOptions, workspaceDir string) error {
+	if opts.keepAlive && opts.stop {
+		return errors.New("--keep-alive and --stop-processes can't be used together")
+	}
+
+	start := time.Now()
+
 	processManager := pm.New(ctx)
 	ctx = context.WithValue(ctx, "pm.ProcessManager", processManager)
+	// processes are still running when a task fails
+	defer processManager.Shutdown()
 
 	out := output.NewStd()
 	ctx = context.WithValue(ctx, "output.OutputFactory", out)
@@ -74,15 +149,475 @@ func run(ctx context.Context, target string, workspaceDir string) error {
 		return err
 	}
 
-	w, err := loadWorkspace(ctx, workspaceDir)
+	hashes := filehash.Load(filepath.Join(filepath.Dir(workspaceFile), internal.StateDir, "filehashes"))
+	ctx = context.WithValue(ctx, "filehash.Cache", hashes)
+	ctx = context.WithValue(ctx, "rules.DefaultTimeout", opts.timeout)
+
+	g, root, err := loadGraph(ctx, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	// the history records the target as it was typed,
+	// the resolved id is only used to look up tasks.
+	requested := target
+	target, err = resolveTarget(ctx, g, root, target)
+	if err != nil {
+		return err
+	}
+
+	engineOpts := []taskengine.Option{
+		taskengine.WithJobs(opts.jobs),
+		taskengine.WithKeepGoing(opts.keepGoing),
+		taskengine.WithHidden(allTargets),
+	}
+
+	var recorder *trace.Recorder
+	if opts.trace != "" {
+		recorder = trace.New()
+		ctx = context.WithValue(ctx, "trace.Recorder", recorder)
+		engineOpts = append(engineOpts, taskengine.WithTracer(recorder))
+	}
+
+	engine := taskengine.New(engineOpts...)
+
+	logrus.Info("original tree")
+	if err := engine.Tree(os.Stdout, g, target); err != nil {
+		return err
+	}
+
+	err = engine.Execute(ctx, g, target)
+
+	if err := hashes.Save(); err != nil {
+		logrus.Warn(errors.Wrap(err, "failed to save file hashes"))
+	}
+
+	durations := history.Load(filepath.Join(filepath.Dir(workspaceFile), internal.StateDir, "durations"))
+	for _, r := range engine.Results() {
+		switch r.Status {
+		case rules.StatusExecuted, rules.StatusFlaky:
+			durations.Set(r.ID, r.Duration)
+		}
+	}
+	if err := durations.Save(); err != nil {
+		logrus.Warn(errors.Wrap(err, "failed to save task durations"))
+	}
+
+	store := history.NewStore(filepath.Join(filepath.Dir(workspaceFile), internal.StateDir, "history"))
+	if err := store.Record(newRun(requested, start, engine.Results(), err)); err != nil {
+		logrus.Warn(errors.Wrap(err, "failed to record run history"))
+	}
+
+	if recorder != nil {
+		if err := writeTrace(recorder, opts.trace); err != nil {
+			logrus.Warn(errors.Wrap(err, "failed to write trace"))
+		}
+	}
+
+	if err := engine.Summary(os.Stdout); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !lo.SomeBy(closure(g, target), isProcess) {
+		return nil
+	}
+
+	if !keepAlive(opts, g, target) {
+		logrus.Info("stopping processes")
+		processManager.Shutdown()
+	} else {
+		logrus.Info("processes are still running, press ctrl+c to stop them")
+	}
+
+	return processManager.Wait()
+}
+
+// keepAlive decides whether processes keep running after the target has
+// executed. By default they do when the target is a process, so it can be
+// used during development, and are stopped when it is a task such as tests.
+// A target from every package is kept alive if any of the tasks it matched
+// is a process.
+func keepAlive(opts runOptions, g taskgraph.TaskGraph, target string) bool {
+	if opts.keepAlive || opts.stop {
+		return opts.keepAlive
+	}
+	if target == allTargets {
+		return lo.SomeBy(g.FindDependencies(target), isProcess)
+	}
+	return isProcess(g.FindTask(target))
+}
+
+func isProcess(r rules.Rule) bool {
+	if c, ok := r.(*rules.Checksum); ok {
+		r = c.Inner
+	}
+	_, ok := r.(*rules.Process)
+	return ok
+}
+
+func clean(ctx context.Context, target string, dryRun bool, workspaceDir string) error {
+	ctx = context.WithValue(ctx, "output.OutputFactory", output.NewStd())
+
+	g, root, err := loadGraph(ctx, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	target, err = resolveTarget(ctx, g, root, target)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, r := range closure(g, target) {
+		c, ok := r.(*rules.Checksum)
+		if !ok {
+			continue
+		}
+
+		paths, err := c.Clean(dryRun)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			if rel, err := filepath.Rel(root, path); err == nil {
+				path = rel
+			}
+			if dryRun {
+				fmt.Printf("would delete %s\n", path)
+			} else {
+				logrus.Debugf("deleted %s", path)
+			}
+		}
+		n += len(paths)
+	}
+
+	if !dryRun {
+		logrus.Infof("deleted %d files", n)
+	}
+
+	return nil
+}
+
+func why(ctx context.Context, target string, workspaceDir string) error {
+	ctx = context.WithValue(ctx, "output.OutputFactory", output.NewStd())
+
+	g, root, err := loadGraph(ctx, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	target, err = resolveTarget(ctx, g, root, target)
+	if err != nil {
+		return err
+	}
+
+	return explain(os.Stdout, g, target)
+}
+
+// explain prints why each task in the closure of the target would be
+// executed by the next run. Tasks are explained after their dependencies
+// so that a task is reported as running when one of them will run.
+func explain(w io.Writer, g taskgraph.TaskGraph, target string) error {
+	runs := map[string]bool{}
+
+	for _, r := range closure(g, target) {
+		c, ok := r.(*rules.Checksum)
+		if !ok {
+			continue
+		}
+
+		reasons, err := c.Explain()
+		if err != nil {
+			return err
+		}
+
+		for _, d := range g.FindDependencies(c.ID()) {
+			if runs[d.ID()] {
+				reasons = append(reasons, fmt.Sprintf("dependency %s will run", d.ID()))
+			}
+		}
+
+		if len(reasons) == 0 {
+			fmt.Fprintf(w, "%s is up-to-date\n", c.ID())
+			continue
+		}
+
+		runs[c.ID()] = true
+		fmt.Fprintf(w, "%s will run because:\n", c.ID())
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "  - %s\n", reason)
+		}
+	}
+
+	return nil
+}
+
+func criticalPath(ctx context.Context, target string, workspaceDir string) error {
+	ctx = context.WithValue(ctx, "output.OutputFactory", output.NewStd())
+
+	workspaceFile, err := findWorkspaceFile(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	g, root, err := loadGraph(ctx, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	target, err = resolveTarget(ctx, g, root, target)
+	if err != nil {
+		return err
+	}
+
+	durations := history.Load(filepath.Join(filepath.Dir(workspaceFile), internal.StateDir, "durations"))
+	p := findCriticalPath(g, target, durations)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tDURATION")
+	for _, s := range p.Steps {
+		duration := "unknown"
+		if s.Known {
+			duration = s.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", s.ID, duration)
+	}
+	fmt.Fprintf(tw, "TOTAL\t%s\n", p.Total.Round(time.Millisecond))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	unknown := 0
+	for _, s := range p.Steps {
+		if !s.Known {
+			unknown++
+		}
+	}
+	if unknown > 0 {
+		logrus.Warnf("%d task(s) on the path have never been executed and are counted as taking no time", unknown)
+	}
+
+	return nil
+}
+
+// findCriticalPath is the critical path of the target without the
+// task that resolveTarget adds, it was never run so its duration is unknown.
+func findCriticalPath(g taskgraph.TaskGraph, target string, durations *history.Durations) history.Path {
+	p := history.CriticalPath(target, g.FindDependencies, durations)
+	p.Steps = lo.Filter(p.Steps, func(s history.Step, i int) bool {
+		return s.ID != allTargets
+	})
+	return p
+}
+
+func newRun(target string, start time.Time, results []taskengine.Result, err error) *history.Run {
+	run := &history.Run{
+		Target:   target,
+		Start:    start,
+		Duration: time.Since(start),
+		Tasks:    []history.TaskRun{},
+	}
+
+	if err != nil {
+		run.ExitCode = 1
+		run.Error = err.Error()
+	}
+
+	for _, r := range results {
+		t := history.TaskRun{
+			ID:       r.ID,
+			Status:   string(r.Status),
+			Start:    r.Start,
+			Duration: r.Duration,
+			Attempts: r.Attempts,
+		}
+		switch r.Status {
+		case rules.StatusCached:
+			t.Cache = "hit"
+		case rules.StatusExecuted, rules.StatusFlaky:
+			t.Cache = "miss"
+		}
+		if r.Err != nil {
+			t.Error = r.Err.Error()
+		}
+		run.Tasks = append(run.Tasks, t)
+	}
+
+	return run
+}
+
+func showHistory(id int, limit int, workspaceDir string) error {
+	workspaceFile, err := findWorkspaceFile(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	store := history.NewStore(filepath.Join(filepath.Dir(workspaceFile), internal.StateDir, "history"))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+
+	if id == 0 {
+		runs, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(runs) > limit {
+			runs = runs[:limit]
+		}
+
+		fmt.Fprintln(tw, "RUN\tSTARTED\tTARGET\tTASKS\tDURATION\tEXIT CODE")
+		for _, r := range runs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%d\n", r.ID, r.Start.Local().Format("2006-01-02 15:04:05"), r.Target, len(r.Tasks), r.Duration.Round(time.Millisecond), r.ExitCode)
+		}
+		return tw.Flush()
+	}
+
+	r, err := store.Get(id)
 	if err != nil {
 		return err
 	}
 
+	fmt.Printf("run:       %d\n", r.ID)
+	fmt.Printf("target:    %s\n", r.Target)
+	fmt.Printf("started:   %s\n", r.Start.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("duration:  %s\n", r.Duration.Round(time.Millisecond))
+	fmt.Printf("exit code: %d\n", r.ExitCode)
+	if r.Error != "" {
+		fmt.Printf("error:     %s\n", r.Error)
+	}
+	fmt.Println()
+
+	fmt.Fprintln(tw, "TASK\tSTATUS\tCACHE\tDURATION")
+	for _, t := range r.Tasks {
+		cache, duration := "-", "-"
+		if t.Cache != "" {
+			cache = t.Cache
+		}
+		if t.Status != string(taskengine.StatusSkipped) {
+			duration = t.Duration.Round(time.Millisecond).String()
+		}
+		status := t.Status
+		if t.Attempts > 1 {
+			status = fmt.Sprintf("%s (%d attempts)", status, t.Attempts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.ID, status, cache, duration)
+	}
+	return tw.Flush()
+}
+
+func writeTrace(recorder *trace.Recorder, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := recorder.WriteJSON(f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func cacheExport(ctx context.Context, target string, file string, workspaceDir string) error {
+	ctx = context.WithValue(ctx, "output.OutputFactory", output.NewStd())
+
+	g, root, err := loadGraph(ctx, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	target, err = resolveTarget(ctx, g, root, target)
+	if err != nil {
+		return err
+	}
+
+	files := []string{}
+	for _, r := range closure(g, target) {
+		if c, ok := r.(*rules.Checksum); ok {
+			x, err := c.Files()
+			if err != nil {
+				return err
+			}
+			files = append(files, x...)
+		}
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := cache.Export(f, filepath.Join(root, internal.StateDir), files); err != nil {
+		return err
+	}
+
+	logrus.Infof("exported %d files to %s", len(files), file)
+	return f.Close()
+}
+
+func cacheImport(ctx context.Context, file string, workspaceDir string) error {
+	workspaceFile, err := findWorkspaceFile(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := cache.Import(f, filepath.Join(filepath.Dir(workspaceFile), internal.StateDir))
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("imported %d files from %s", n, file)
+	return nil
+}
+
+// loadGraph builds the task graph for every rule in the workspace
+// and returns it along with the workspace root directory.
+func loadGraph(ctx context.Context, workspaceDir string) (taskgraph.TaskGraph, string, error) {
+	out := ctx.Value("output.OutputFactory").(output.OutputFactory)
+	hashes, _ := ctx.Value("filehash.Cache").(*filehash.Cache)
+
+	workspaceFile, err := findWorkspaceFile(workspaceDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	root := filepath.Dir(workspaceFile)
+
+	w, err := loadWorkspace(ctx, workspaceDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := workspace.LoadConfig(ctx, workspaceFile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := cache.New(filepath.Join(root, internal.StateDir, "cache"))
+	if config.RemoteCache != nil {
+		c.Remote = cache.NewHTTP(config.RemoteCache.URL)
+		c.ReadOnly = config.RemoteCache.ReadOnly || *readOnlyFlag
+	}
+
 	for i := 0; i < len(w); i++ {
 		w[i] = &rules.Checksum{
 			Inner:        w[i],
-			WorkspaceDir: filepath.Dir(workspaceFile),
+			WorkspaceDir: root,
+			Cache:        c,
+			Hashes:       hashes,
 			Stdout:       out.Stdout(w[i].ID()),
 		}
 	}
@@ -91,24 +626,40 @@ func run(ctx context.Context, target string, workspaceDir string) error {
 
 	for _, r := range w {
 		if err := g.AddTask(r); err != nil {
-			return err
+			return nil, "", err
 		}
 	}
 
 	for _, r := range w {
 		for _, d := range r.Dependencies() {
 			if err := g.AddDependency(r.ID(), d); err != nil {
-				return err
+				return nil, "", err
 			}
 		}
 	}
 
+	for _, r := range w {
+		r.(*rules.Checksum).Upstream = g.FindDependencies(r.ID())
+	}
+
+	return g, root, nil
+}
+
+// allTargets is the id of the task that resolveTarget adds
+// to run a target from every package.
+const allTargets = "//-"
+
+// resolveTarget turns the target given on the command line
+// into the id of a task in the graph.
+func resolveTarget(ctx context.Context, g taskgraph.TaskGraph, root string, target string) (string, error) {
+	out := ctx.Value("output.OutputFactory").(output.OutputFactory)
+
 	// hack so that you can run "taskgraph run .:target" as a shorthand
 	// for "//current/package:target"
 	if strings.HasPrefix(target, ".:") {
-		pkg, err := filepath.Rel(filepath.Dir(workspaceFile), cwd)
+		pkg, err := filepath.Rel(root, cwd)
 		if err != nil {
-			return err
+			return "", err
 		}
 		target = strings.Replace(target, ".", "//"+pkg, 1)
 	}
@@ -116,14 +667,15 @@ func run(ctx context.Context, target string, workspaceDir string) error {
 	// hack so that you can run "taskgraph run :target" in a workspace
 	// to run all matching targets from all packages
 	if strings.HasPrefix(target, ":") {
-		iid := "//-"
+		iid := allTargets
+		tasks := g.Tasks()
 		g.AddTask(&rules.Task{
 			IID:    iid,
 			Cmds:   []string{},
 			Stdout: out.Stdout(iid),
 			Stderr: out.Stderr(iid),
 		})
-		for _, r := range w {
+		for _, r := range tasks {
 			if strings.HasSuffix(r.ID(), target) {
 				g.AddDependency(iid, r.ID())
 			}
@@ -136,18 +688,29 @@ func run(ctx context.Context, target string, workspaceDir string) error {
 		target = "//" + target
 	}
 
-	engine := taskengine.New()
+	return target, nil
+}
 
-	logrus.Info("original tree")
-	if err := engine.Tree(os.Stdout, g, target); err != nil {
-		return err
-	}
+// closure returns the task and everything it transitively depends on,
+// each task comes after its dependencies.
+func closure(g taskgraph.TaskGraph, target string) []rules.Rule {
+	seen := map[string]bool{}
+	result := []rules.Rule{}
 
-	if err := engine.Execute(ctx, g, target); err != nil {
-		return err
+	var walk func(r rules.Rule)
+	walk = func(r rules.Rule) {
+		if r == nil || seen[r.ID()] {
+			return
+		}
+		seen[r.ID()] = true
+		for _, d := range g.FindDependencies(r.ID()) {
+			walk(d)
+		}
+		result = append(result, r)
 	}
+	walk(g.FindTask(target))
 
-	return processManager.Wait()
+	return result
 }
 
 func list(ctx context.Context, workspaceDir string) error {
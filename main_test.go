@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"taskgraph/internal/history"
+	"taskgraph/internal/output"
+	"taskgraph/internal/rules"
+	"taskgraph/internal/taskgraph"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.WithValue(context.Background(), "output.OutputFactory", output.NewStd())
+
+	g := taskgraph.New()
+	require.NoError(g.AddTask(&rules.Task{IID: "//a:build"}))
+	require.NoError(g.AddTask(&rules.Process{IID: "//a:serve"}))
+	require.NoError(g.AddTask(&rules.Process{IID: "//b:serve"}))
+
+	require.True(keepAlive(runOptions{}, g, "//a:serve"))
+	require.False(keepAlive(runOptions{}, g, "//a:build"))
+	require.False(keepAlive(runOptions{stop: true}, g, "//a:serve"))
+	require.True(keepAlive(runOptions{keepAlive: true}, g, "//a:build"))
+
+	// a target from every package is a process when the tasks it matched are
+	target, err := resolveTarget(ctx, g, "", ":serve")
+	require.NoError(err)
+	require.Equal(allTargets, target)
+	require.True(keepAlive(runOptions{}, g, target))
+}
+
+func TestExplainDependencyWillRun(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	require.NoError(os.MkdirAll(filepath.Join(dir, "b"), 0755))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a", "in.txt"), []byte("hi"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "b", "in.txt"), []byte("hi"), 0644))
+
+	newTask := func(id string, pkg string, deps ...string) *rules.Checksum {
+		return &rules.Checksum{
+			Inner: &rules.Task{
+				IID:    id,
+				Deps:   deps,
+				Srcs:   []string{"in.txt"},
+				Outs:   []string{"out.txt"},
+				Cmds:   []string{"cat in.txt > out.txt"},
+				Cwd:    filepath.Join(dir, pkg),
+				Stdout: ioutil.Discard,
+				Stderr: ioutil.Discard,
+			},
+			WorkspaceDir: dir,
+			Stdout:       ioutil.Discard,
+		}
+	}
+
+	b := newTask("//b:build", "b")
+	a := newTask("//a:build", "a", "//b:build")
+	a.Upstream = []rules.Rule{b}
+
+	g := taskgraph.New()
+	require.NoError(g.AddTask(a))
+	require.NoError(g.AddTask(b))
+	require.NoError(g.AddDependency("//a:build", "//b:build"))
+
+	require.NoError(b.Execute(context.Background()))
+	require.NoError(a.Execute(context.Background()))
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "b", "in.txt"), []byte("bye"), 0644))
+
+	out := &bytes.Buffer{}
+	require.NoError(explain(out, g, "//a:build"))
+	require.Equal(`//b:build will run because:
+  - input in.txt was modified
+//a:build will run because:
+  - dependency //b:build will run
+`, out.String())
+}
+
+func TestCriticalPathOfAllTargets(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.WithValue(context.Background(), "output.OutputFactory", output.NewStd())
+
+	g := taskgraph.New()
+	require.NoError(g.AddTask(&rules.Task{IID: "//a:build"}))
+	require.NoError(g.AddTask(&rules.Task{IID: "//b:build"}))
+
+	durations := history.Load(filepath.Join(t.TempDir(), "durations"))
+	durations.Set("//a:build", time.Second)
+	durations.Set("//b:build", 2*time.Second)
+
+	target, err := resolveTarget(ctx, g, "", ":build")
+	require.NoError(err)
+
+	p := findCriticalPath(g, target, durations)
+	require.Equal([]history.Step{{ID: "//b:build", Duration: 2 * time.Second, Known: true}}, p.Steps)
+	require.Equal(2*time.Second, p.Total)
+}